@@ -0,0 +1,33 @@
+package common
+
+import (
+	"crypto/subtle"
+	"cuore/config"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth protects routes with the admin credentials from the config using
+// HTTP basic auth. Every request is rejected while no admin password is set.
+func AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if config.Get().AdminPassword == "" {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "admin credentials are not configured"})
+			return
+		}
+
+		username, password, ok := c.Request.BasicAuth()
+		if !ok || !secureCompare(username, config.Get().AdminUsername) || !secureCompare(password, config.Get().AdminPassword) {
+			c.Header("WWW-Authenticate", `Basic realm="cuore"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func secureCompare(given string, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
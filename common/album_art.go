@@ -0,0 +1,184 @@
+package common
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"cuore/config"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxAlbumArtSize limits the size of a cached image.
+	maxAlbumArtSize = 10 << 20
+	// maxAlbumArtURLs bounds the known source URLs, least recently used
+	// first out.
+	maxAlbumArtURLs = 1000
+)
+
+// albumArt maps the ids of proxied images to their source URL. Images are
+// cached in ALBUM_ART_CACHE_DIR on first request, so clients on the local
+// network never need internet access. The directory is trimmed to
+// ALBUM_ART_CACHE_MAX_MB, oldest images first.
+var albumArt = struct {
+	mutex  sync.Mutex
+	urls   map[string]*list.Element
+	recent *list.List // of albumArtEntry, most recently used first
+}{urls: map[string]*list.Element{}, recent: list.New()}
+
+type albumArtEntry struct {
+	id     string
+	source string
+}
+
+var albumArtClient = &http.Client{}
+
+// AlbumArtURL returns the URL under which cuore proxies the image at source.
+func AlbumArtURL(source string) string {
+	if source == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(source))
+	id := hex.EncodeToString(sum[:16])
+
+	albumArt.mutex.Lock()
+	if element, ok := albumArt.urls[id]; ok {
+		albumArt.recent.MoveToFront(element)
+	} else {
+		albumArt.urls[id] = albumArt.recent.PushFront(albumArtEntry{id: id, source: source})
+		for albumArt.recent.Len() > maxAlbumArtURLs {
+			oldest := albumArt.recent.Remove(albumArt.recent.Back()).(albumArtEntry)
+			delete(albumArt.urls, oldest.id)
+		}
+	}
+	albumArt.mutex.Unlock()
+
+	return strings.TrimSuffix(config.Get().PublicURL, "/") + "/album-art/" + id
+}
+
+func albumArtSource(id string) (string, bool) {
+	albumArt.mutex.Lock()
+	defer albumArt.mutex.Unlock()
+
+	element, ok := albumArt.urls[id]
+	if !ok {
+		return "", false
+	}
+	albumArt.recent.MoveToFront(element)
+	return element.Value.(albumArtEntry).source, true
+}
+
+// AlbumArtHandler serves a proxied image from the cache, fetching it first
+// if needed.
+func AlbumArtHandler(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := hex.DecodeString(id); err != nil || len(id) != 32 {
+		c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound, "message": "Album art not found"})
+		return
+	}
+
+	path := filepath.Join(config.Get().AlbumArtCacheDir, id)
+	if _, err := os.Stat(path); err == nil {
+		// the modification time orders images for trimming the cache
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		c.File(path)
+		return
+	}
+
+	source, ok := albumArtSource(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound, "message": "Album art not found"})
+		return
+	}
+
+	data, err := fetchAlbumArt(c, source)
+	if err != nil {
+		slog.WarnContext(c, "Error fetching album art", "url", source, "error", err)
+		c.JSON(http.StatusBadGateway, gin.H{"code": http.StatusBadGateway, "message": "Failed to fetch album art"})
+		return
+	}
+
+	if err := writeFileAtomic(path, data); err != nil {
+		slog.WarnContext(c, "Error caching album art", "error", err)
+	} else if err := trimAlbumArtCache(config.Get().AlbumArtCacheDir, int64(config.Get().AlbumArtCacheMaxMB)<<20); err != nil {
+		slog.WarnContext(c, "Error trimming album art cache", "error", err)
+	}
+	c.Data(http.StatusOK, http.DetectContentType(data), data)
+}
+
+func fetchAlbumArt(c *gin.Context, source string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(c, config.Get().VendorRequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", source, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := albumArtClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAlbumArtSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxAlbumArtSize {
+		return nil, errors.New("image too large")
+	}
+	return data, nil
+}
+
+// trimAlbumArtCache removes the least recently served images until the
+// directory holds at most maxSize bytes.
+func trimAlbumArtCache(dir string, maxSize int64) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var files []fs.FileInfo
+	var size int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, info)
+		size += info.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, file := range files {
+		if size <= maxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
+			return err
+		}
+		size -= file.Size()
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"cuore/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAlbumArtIsCached(t *testing.T) {
+	config.Get().AlbumArtCacheDir = t.TempDir()
+	config.Get().AlbumArtCacheMaxMB = 1
+	config.Get().VendorRequestTimeout = time.Second
+	defer func() { config.Get().AlbumArtCacheDir = "" }()
+
+	requests := 0
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("\x89PNG\r\n\x1a\nimage"))
+	}))
+	defer source.Close()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/album-art/:id", AlbumArtHandler)
+
+	id := path.Base(AlbumArtURL(source.URL + "/cover.png"))
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest("GET", "/album-art/"+id, nil))
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", recorder.Code)
+		}
+		if recorder.Header().Get("Content-Type") != "image/png" {
+			t.Fatalf("expected image/png, got %s", recorder.Header().Get("Content-Type"))
+		}
+	}
+
+	if requests != 1 {
+		t.Fatalf("expected the image to be fetched once, got %d requests", requests)
+	}
+}
+
+func TestAlbumArtCacheTrimsOldestImages(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"old", "middle", "new"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, make([]byte, 100), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		modified := now.Add(time.Duration(i-3) * time.Minute)
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := trimAlbumArtCache(dir, 200); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "old")); !os.IsNotExist(err) {
+		t.Fatal("expected the oldest image to be removed")
+	}
+	for _, name := range []string{"middle", "new"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("expected %s to be kept: %v", name, err)
+		}
+	}
+}
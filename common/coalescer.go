@@ -0,0 +1,194 @@
+package common
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Coalescer throttles rapid-fire commands such as volume or brightness
+// changes from knobs and sliders. The first command for a key is submitted
+// right away; commands arriving within the window after it only replace
+// each other, and the latest one is submitted when the window ends.
+type Coalescer struct {
+	window  time.Duration
+	actions map[string]bool
+
+	mutex   sync.Mutex
+	windows map[string]*coalesceWindow
+	stopped bool
+}
+
+type coalesceWindow struct {
+	timer  *time.Timer
+	latest *heldCommand
+}
+
+type heldCommand struct {
+	submit  func()
+	dropped func()
+}
+
+// NewCoalescer coalesces the given actions. A zero window disables it.
+func NewCoalescer(window time.Duration, actions []string) *Coalescer {
+	return &Coalescer{
+		window:  window,
+		actions: toSet(actions),
+		windows: map[string]*coalesceWindow{},
+	}
+}
+
+// Coalesces reports whether commands for action are coalesced.
+func (c *Coalescer) Coalesces(action string) bool {
+	return c.window > 0 && c.actions[action]
+}
+
+// Submit calls submit now or at the end of the current window for key,
+// unless a later command for key replaces it first. dropped, if not nil, is
+// called instead of submit for a command that is replaced or held back when
+// the coalescer stops.
+func (c *Coalescer) Submit(key string, submit func(), dropped func()) {
+	c.mutex.Lock()
+	if c.stopped {
+		c.mutex.Unlock()
+		if dropped != nil {
+			dropped()
+		}
+		return
+	}
+
+	if window, ok := c.windows[key]; ok {
+		replaced := window.latest
+		window.latest = &heldCommand{submit: submit, dropped: dropped}
+		c.mutex.Unlock()
+		if replaced != nil && replaced.dropped != nil {
+			replaced.dropped()
+		}
+		return
+	}
+
+	c.windows[key] = &coalesceWindow{timer: time.AfterFunc(c.window, func() { c.closeWindow(key) })}
+	c.mutex.Unlock()
+
+	// submitted synchronously to keep its order with commands that are not
+	// coalesced
+	submit()
+}
+
+func (c *Coalescer) closeWindow(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	window, ok := c.windows[key]
+	if !ok || c.stopped {
+		return
+	}
+
+	if window.latest == nil {
+		delete(c.windows, key)
+		return
+	}
+
+	// the trailing command opens a new window, so a knob that keeps turning
+	// is still limited to one command per window
+	held := window.latest
+	window.latest = nil
+	window.timer = time.AfterFunc(c.window, func() { c.closeWindow(key) })
+
+	// submitted with the mutex held, so Flush cannot overtake it
+	held.submit()
+}
+
+// Flush submits the commands held back for keys starting with prefix right
+// away. It is called before a command that is not coalesced, so it does not
+// overtake older values for the same room.
+func (c *Coalescer) Flush(prefix string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.stopped {
+		return
+	}
+
+	for key, window := range c.windows {
+		if window.latest == nil || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		held := window.latest
+		window.latest = nil
+		held.submit()
+	}
+}
+
+// Stop drops all commands still held back.
+func (c *Coalescer) Stop() {
+	c.mutex.Lock()
+	var held []*heldCommand
+	c.stopped = true
+	for key, window := range c.windows {
+		window.timer.Stop()
+		if window.latest != nil {
+			held = append(held, window.latest)
+		}
+		delete(c.windows, key)
+	}
+	c.mutex.Unlock()
+
+	for _, command := range held {
+		if command.dropped != nil {
+			command.dropped()
+		}
+	}
+}
+
+// Debouncer drops repeated commands, such as play/pause toggles from a
+// bouncy button, that arrive within the interval after an accepted one.
+type Debouncer struct {
+	interval time.Duration
+	actions  map[string]bool
+
+	mutex sync.Mutex
+	last  map[string]time.Time
+}
+
+// NewDebouncer debounces the given actions. A zero interval disables it.
+func NewDebouncer(interval time.Duration, actions []string) *Debouncer {
+	return &Debouncer{
+		interval: interval,
+		actions:  toSet(actions),
+		last:     map[string]time.Time{},
+	}
+}
+
+// Allow reports whether a command for action under key should be handled.
+func (d *Debouncer) Allow(key string, action string) bool {
+	if d.interval <= 0 || !d.actions[action] {
+		return true
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	if last, ok := d.last[key]; ok && now.Sub(last) < d.interval {
+		return false
+	}
+
+	// forget keys that can no longer suppress anything
+	for k, last := range d.last {
+		if now.Sub(last) >= d.interval {
+			delete(d.last, k)
+		}
+	}
+
+	d.last[key] = now
+	return true
+}
+
+func toSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, value := range values {
+		set[value] = true
+	}
+	return set
+}
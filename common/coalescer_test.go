@@ -0,0 +1,84 @@
+package common
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalescerKeepsLatestValue(t *testing.T) {
+	c := NewCoalescer(50*time.Millisecond, []string{"volume"})
+
+	var mutex sync.Mutex
+	var submitted []int
+	dropped := 0
+	for i := 0; i < 10; i++ {
+		i := i
+		c.Submit("music/kitchen/volume", func() {
+			mutex.Lock()
+			defer mutex.Unlock()
+			submitted = append(submitted, i)
+		}, func() {
+			mutex.Lock()
+			defer mutex.Unlock()
+			dropped++
+		})
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(submitted) != 2 || submitted[0] != 0 || submitted[1] != 9 {
+		t.Fatalf("expected first and latest value, got %v", submitted)
+	}
+	if dropped != 8 {
+		t.Fatalf("expected 8 replaced values to be reported, got %d", dropped)
+	}
+}
+
+func TestCoalescerFlushKeepsOrder(t *testing.T) {
+	c := NewCoalescer(time.Hour, []string{"volume"})
+	defer c.Stop()
+
+	var submitted []string
+	c.Submit("music/kitchen/volume", func() { submitted = append(submitted, "volume 10") }, nil)
+	c.Submit("music/kitchen/volume", func() { submitted = append(submitted, "volume 20") }, nil)
+
+	// a pause for the same room must not overtake the held back volume
+	c.Flush("music/kitchen/")
+	submitted = append(submitted, "pause")
+
+	if len(submitted) != 3 || submitted[1] != "volume 20" || submitted[2] != "pause" {
+		t.Fatalf("expected the held back volume before the pause, got %v", submitted)
+	}
+}
+
+func TestCoalescerIgnoresOtherActions(t *testing.T) {
+	c := NewCoalescer(time.Second, []string{"volume"})
+	if c.Coalesces("play") {
+		t.Fatal("play must not be coalesced")
+	}
+	if NewCoalescer(0, []string{"volume"}).Coalesces("volume") {
+		t.Fatal("a zero window must disable coalescing")
+	}
+}
+
+func TestDebouncerDropsRepeatedToggles(t *testing.T) {
+	d := NewDebouncer(50*time.Millisecond, []string{"play", "pause"})
+
+	if !d.Allow("music/kitchen/play", "play") {
+		t.Fatal("first play must be allowed")
+	}
+	if d.Allow("music/kitchen/play", "play") {
+		t.Fatal("repeated play must be dropped")
+	}
+	if !d.Allow("music/kitchen/pause", "pause") {
+		t.Fatal("pause after play must be allowed")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !d.Allow("music/kitchen/play", "play") {
+		t.Fatal("play after the interval must be allowed")
+	}
+}
@@ -0,0 +1,278 @@
+package common
+
+import (
+	"bytes"
+	"cuore/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileCredentialStore keeps one encrypted file per credential, named
+// <provider>.<account>.<name>. Files named only after the provider are
+// tokens of the default account written by earlier versions; they are
+// migrated on first read.
+type fileCredentialStore struct {
+	dir string
+}
+
+func newFileCredentialStore(dir string) *fileCredentialStore {
+	return &fileCredentialStore{dir: dir}
+}
+
+func (s *fileCredentialStore) path(key CredentialKey) string {
+	parts := []string{key.Provider, key.Account, key.Name}
+	for i, part := range parts {
+		parts[i] = strings.ReplaceAll(url.PathEscape(part), ".", "%2E")
+	}
+	return filepath.Join(s.dir, strings.Join(parts, "."))
+}
+
+func (s *fileCredentialStore) legacyPath(key CredentialKey) (string, bool) {
+	if key.Account != DefaultAccount || key.Name != tokenCredential {
+		return "", false
+	}
+	return filepath.Join(s.dir, key.Provider), true
+}
+
+func (s *fileCredentialStore) Get(key CredentialKey) ([]byte, error) {
+	path := s.path(key)
+	encrypted, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if legacyPath, ok := s.legacyPath(key); ok {
+			return s.migrate(key, legacyPath)
+		}
+		return nil, ErrCredentialNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading credential from file: %w", err)
+	}
+
+	value, legacy, err := decryptFile(config.Get().EncryptionKey, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	if legacy {
+		// the file is replaced in place, so keep a copy until the new one
+		// has been verified
+		backupPath := path + ".bak"
+		if err := writeFileAtomic(backupPath, encrypted); err != nil {
+			return nil, fmt.Errorf("failed to back up credential: %w", err)
+		}
+
+		slog.Info("Migrating credential to the current encryption format", "credential", key.String())
+		if err := s.putVerified(key, value); err != nil {
+			if restoreErr := os.Rename(backupPath, path); restoreErr != nil {
+				slog.Error("Error restoring credential, a backup is kept", "file", backupPath, "error", restoreErr)
+			}
+			return nil, fmt.Errorf("failed to migrate credential: %w", err)
+		}
+		os.Remove(backupPath)
+	}
+
+	return value, nil
+}
+
+// migrate moves a token file of an earlier version to its current name and
+// encryption format. The old file is only removed once the new one has been
+// read back.
+func (s *fileCredentialStore) migrate(key CredentialKey, legacyPath string) ([]byte, error) {
+	encrypted, err := os.ReadFile(legacyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCredentialNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading credential from file: %w", err)
+	}
+
+	value, _, err := decryptFile(config.Get().EncryptionKey, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Migrating token file", "file", legacyPath, "credential", key.String())
+	if err := s.putVerified(key, value); err != nil {
+		os.Remove(s.path(key))
+		return nil, fmt.Errorf("failed to migrate credential: %w", err)
+	}
+	if err := os.Remove(legacyPath); err != nil {
+		return nil, fmt.Errorf("failed to remove migrated token file: %w", err)
+	}
+
+	return value, nil
+}
+
+// putVerified writes a credential and reads it back, so a migration never
+// discards the only readable copy.
+func (s *fileCredentialStore) putVerified(key CredentialKey, value []byte) error {
+	if err := s.Put(key, value); err != nil {
+		return err
+	}
+
+	encrypted, err := os.ReadFile(s.path(key))
+	if err != nil {
+		return fmt.Errorf("failed to read back credential: %w", err)
+	}
+	written, err := decrypt(config.Get().EncryptionKey, encrypted)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt written credential: %w", err)
+	}
+	if !bytes.Equal(written, value) {
+		return errors.New("written credential does not match")
+	}
+	return nil
+}
+
+func (s *fileCredentialStore) Put(key CredentialKey, value []byte) error {
+	encrypted, err := encrypt(config.Get().EncryptionKey, value)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("Saving credential", "credential", key.String())
+	return writeFileAtomic(s.path(key), encrypted)
+}
+
+func (s *fileCredentialStore) Delete(key CredentialKey) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *fileCredentialStore) Keys() ([]CredentialKey, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credential directory: %w", err)
+	}
+
+	var keys []CredentialKey
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		parts := strings.Split(entry.Name(), ".")
+		if len(parts) == 1 {
+			keys = append(keys, CredentialKey{Provider: parts[0], Account: DefaultAccount, Name: tokenCredential})
+			continue
+		}
+		if len(parts) != 3 {
+			continue
+		}
+
+		for i, part := range parts {
+			if parts[i], err = url.PathUnescape(part); err != nil {
+				return nil, fmt.Errorf("invalid credential file name %s: %w", entry.Name(), err)
+			}
+		}
+		keys = append(keys, CredentialKey{Provider: parts[0], Account: parts[1], Name: parts[2]})
+	}
+
+	return keys, nil
+}
+
+// Rotate decrypts every credential before the first one is written, so a
+// credential that cannot be read with the current key leaves all untouched.
+// The re-encrypted credentials are written to temporary files first and only
+// then moved into place; if a move fails, the moved ones are rolled back.
+func (s *fileCredentialStore) Rotate(newKey string) error {
+	keys, err := s.Keys()
+	if err != nil {
+		return err
+	}
+
+	var rotations []fileRotation
+	defer func() {
+		for _, r := range rotations {
+			os.Remove(r.temporary)
+		}
+	}()
+
+	for _, key := range keys {
+		value, err := s.Get(key)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", key, err)
+		}
+
+		// Get migrates legacy files, so read what is on disk now
+		previous, err := os.ReadFile(s.path(key))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", key, err)
+		}
+
+		encrypted, err := encrypt(newKey, value)
+		if err != nil {
+			return err
+		}
+
+		r := fileRotation{key: key, previous: previous, temporary: filepath.Join(s.dir, "."+filepath.Base(s.path(key))+".rotate")}
+		if err := writeFileAtomic(r.temporary, encrypted); err != nil {
+			return fmt.Errorf("failed to write %s: %w", key, err)
+		}
+		rotations = append(rotations, r)
+	}
+
+	for i, r := range rotations {
+		if err := os.Rename(r.temporary, s.path(r.key)); err != nil {
+			s.rollback(rotations[:i])
+			return fmt.Errorf("failed to replace %s, no credential was rotated: %w", r.key, err)
+		}
+	}
+
+	for _, r := range rotations {
+		slog.Info("Re-encrypted credential", "credential", r.key.String())
+	}
+	return nil
+}
+
+// fileRotation is a credential re-encrypted by Rotate but not yet moved into
+// place.
+type fileRotation struct {
+	key       CredentialKey
+	previous  []byte
+	temporary string
+}
+
+// rollback restores credentials already moved into place by Rotate.
+func (s *fileCredentialStore) rollback(rotations []fileRotation) {
+	for _, r := range rotations {
+		if err := writeFileAtomic(s.path(r.key), r.previous); err != nil {
+			slog.Error("Error rolling back credential, it is encrypted with the new key", "credential", r.key.String(), "error", err)
+		}
+	}
+}
+
+func (s *fileCredentialStore) Close() error {
+	return nil
+}
+
+// decryptFile decrypts the content of a credential file in either the
+// current or the legacy format and reports whether it was a legacy file.
+// Legacy files are also tried with the former default key.
+func decryptFile(passphrase string, encrypted []byte) ([]byte, bool, error) {
+	data, err := decrypt(passphrase, encrypted)
+	if !errors.Is(err, errLegacyFormat) {
+		return data, false, err
+	}
+
+	for _, key := range []string{passphrase, config.LegacyEncryptionKey} {
+		data, err := decryptLegacy(key, encrypted)
+		// the legacy format is not authenticated, a wrong key of valid
+		// length yields garbage rather than an error
+		if err == nil && json.Valid(data) {
+			return data, true, nil
+		}
+	}
+	return nil, true, errors.New("failed to decrypt legacy credential, check ENCRYPTION_KEY")
+}
@@ -0,0 +1,157 @@
+package common
+
+import (
+	"cuore/config"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteCredentialStore keeps all credentials in one SQLite database. Values
+// are encrypted the same way as in the file store.
+type sqliteCredentialStore struct {
+	db *sql.DB
+}
+
+func newSQLiteCredentialStore(path string) (*sqliteCredentialStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open credential database: %w", err)
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS credentials (
+		provider   TEXT NOT NULL,
+		account    TEXT NOT NULL,
+		name       TEXT NOT NULL,
+		value      BLOB NOT NULL,
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (provider, account, name)
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create credential table: %w", err)
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteCredentialStore{db: db}, nil
+}
+
+func (s *sqliteCredentialStore) Get(key CredentialKey) ([]byte, error) {
+	var encrypted []byte
+	err := s.db.QueryRow(
+		`SELECT value FROM credentials WHERE provider = ? AND account = ? AND name = ?`,
+		key.Provider, key.Account, key.Name,
+	).Scan(&encrypted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrCredentialNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credential: %w", err)
+	}
+
+	return decrypt(config.Get().EncryptionKey, encrypted)
+}
+
+func (s *sqliteCredentialStore) Put(key CredentialKey, value []byte) error {
+	encrypted, err := encrypt(config.Get().EncryptionKey, value)
+	if err != nil {
+		return err
+	}
+
+	return s.put(s.db, key, encrypted)
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func (s *sqliteCredentialStore) put(db execer, key CredentialKey, encrypted []byte) error {
+	_, err := db.Exec(
+		`INSERT INTO credentials (provider, account, name, value, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (provider, account, name) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+		key.Provider, key.Account, key.Name, encrypted, time.Now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write credential: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteCredentialStore) Delete(key CredentialKey) error {
+	_, err := s.db.Exec(
+		`DELETE FROM credentials WHERE provider = ? AND account = ? AND name = ?`,
+		key.Provider, key.Account, key.Name,
+	)
+	return err
+}
+
+func (s *sqliteCredentialStore) Keys() ([]CredentialKey, error) {
+	rows, err := s.db.Query(`SELECT provider, account, name FROM credentials`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list credentials: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []CredentialKey
+	for rows.Next() {
+		var key CredentialKey
+		if err := rows.Scan(&key.Provider, &key.Account, &key.Name); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// Rotate re-encrypts all credentials in a single transaction.
+func (s *sqliteCredentialStore) Rotate(newKey string) error {
+	keys, err := s.Keys()
+	if err != nil {
+		return err
+	}
+
+	values := map[CredentialKey][]byte{}
+	for _, key := range keys {
+		value, err := s.Get(key)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", key, err)
+		}
+		values[key] = value
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for key, value := range values {
+		encrypted, err := encrypt(newKey, value)
+		if err != nil {
+			return err
+		}
+		if err := s.put(tx, key, encrypted); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteCredentialStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,152 @@
+package common
+
+import (
+	"cuore/config"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	DefaultAccount = "default"
+
+	tokenCredential = "token"
+)
+
+var ErrCredentialNotFound = errors.New("credential not found")
+
+// CredentialKey identifies a stored credential. Name distinguishes the OAuth
+// token of an account from other secrets, such as the Hue application key.
+type CredentialKey struct {
+	Provider string
+	Account  string
+	Name     string
+}
+
+func (k CredentialKey) String() string {
+	return fmt.Sprintf("%s/%s/%s", k.Provider, k.Account, k.Name)
+}
+
+// CredentialStore persists encrypted credentials. Get returns
+// ErrCredentialNotFound for unknown keys.
+type CredentialStore interface {
+	Get(key CredentialKey) ([]byte, error)
+	Put(key CredentialKey, value []byte) error
+	Delete(key CredentialKey) error
+	Keys() ([]CredentialKey, error)
+	// Rotate re-encrypts every credential with newKey.
+	Rotate(newKey string) error
+	Close() error
+}
+
+var (
+	credentialStore     CredentialStore
+	credentialStoreOnce sync.Once
+	credentialStoreErr  error
+)
+
+// OpenCredentialStore opens the backend selected in the config. It is safe to
+// call more than once.
+func OpenCredentialStore() (CredentialStore, error) {
+	credentialStoreOnce.Do(func() {
+		var backend CredentialStore
+		switch config.Get().CredentialStore {
+		case "file":
+			backend = newFileCredentialStore(config.Get().EncryptionFilePath)
+		case "sqlite":
+			backend, credentialStoreErr = newSQLiteCredentialStore(config.Get().CredentialDBPath)
+		default:
+			credentialStoreErr = fmt.Errorf("unknown credential store: %s", config.Get().CredentialStore)
+		}
+
+		if credentialStoreErr == nil {
+			credentialStore = newCachedCredentialStore(backend)
+		}
+	})
+
+	return credentialStore, credentialStoreErr
+}
+
+// CloseCredentialStore closes the credential store if it has been opened.
+func CloseCredentialStore() error {
+	if credentialStore == nil {
+		return nil
+	}
+	return credentialStore.Close()
+}
+
+// cachedCredentialStore keeps every credential read or written in memory and
+// writes through to its backend.
+type cachedCredentialStore struct {
+	backend CredentialStore
+	mutex   sync.RWMutex
+	cache   map[CredentialKey][]byte
+}
+
+func newCachedCredentialStore(backend CredentialStore) *cachedCredentialStore {
+	return &cachedCredentialStore{
+		backend: backend,
+		cache:   map[CredentialKey][]byte{},
+	}
+}
+
+func (s *cachedCredentialStore) Get(key CredentialKey) ([]byte, error) {
+	s.mutex.RLock()
+	value, ok := s.cache[key]
+	s.mutex.RUnlock()
+	if ok {
+		return value, nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	value, err := s.backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	s.cache[key] = value
+	return value, nil
+}
+
+func (s *cachedCredentialStore) Put(key CredentialKey, value []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.backend.Put(key, value); err != nil {
+		return err
+	}
+
+	s.cache[key] = value
+	return nil
+}
+
+func (s *cachedCredentialStore) Delete(key CredentialKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.cache, key)
+	return s.backend.Delete(key)
+}
+
+func (s *cachedCredentialStore) Keys() ([]CredentialKey, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.backend.Keys()
+}
+
+func (s *cachedCredentialStore) Rotate(newKey string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.backend.Rotate(newKey)
+}
+
+func (s *cachedCredentialStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.backend.Close()
+}
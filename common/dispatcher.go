@@ -0,0 +1,132 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrQueueFull = errors.New("command queue is full")
+
+// Dispatcher runs commands on one ordered queue per key, e.g. per target and
+// room. Commands with the same key run one after another in submission
+// order, while different keys run concurrently on a bounded worker pool.
+// Keys start with the target, which labels the queue depth metric; rooms
+// come from message payloads and would create a series per value.
+type Dispatcher struct {
+	maxDepth int
+	timeout  time.Duration
+	workers  chan struct{}
+
+	mutex   sync.Mutex
+	queues  map[string][]command
+	pending sync.WaitGroup
+}
+
+type command struct {
+	ctx  context.Context
+	run  func(ctx context.Context) error
+	done func(err error)
+}
+
+func NewDispatcher(workers int, maxDepth int, timeout time.Duration) *Dispatcher {
+	return &Dispatcher{
+		maxDepth: maxDepth,
+		timeout:  timeout,
+		workers:  make(chan struct{}, workers),
+		queues:   map[string][]command{},
+	}
+}
+
+// Submit queues run behind all commands submitted earlier for key. run gets
+// a context derived from ctx with the command timeout applied; done, if not
+// nil, receives its result. Submit returns ErrQueueFull without queuing
+// when key already has the maximum number of waiting commands.
+func (d *Dispatcher) Submit(ctx context.Context, key string, run func(ctx context.Context) error, done func(err error)) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	queue, running := d.queues[key]
+	if len(queue) >= d.maxDepth {
+		return ErrQueueFull
+	}
+
+	d.queues[key] = append(queue, command{ctx: ctx, run: run, done: done})
+	queueDepth.WithLabelValues(queueTarget(key)).Inc()
+	d.pending.Add(1)
+
+	if !running {
+		go d.drain(key)
+	}
+
+	return nil
+}
+
+// Depth returns the number of commands waiting for key.
+func (d *Dispatcher) Depth(key string) int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return len(d.queues[key])
+}
+
+// Wait blocks until every submitted command has finished or ctx is done.
+// Commands whose context is already cancelled still run, each with a fresh
+// command timeout.
+func (d *Dispatcher) Wait(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain runs the commands of key until its queue is empty. A queue is
+// present in d.queues for as long as its drain goroutine runs.
+func (d *Dispatcher) drain(key string) {
+	for {
+		d.mutex.Lock()
+		queue := d.queues[key]
+		if len(queue) == 0 {
+			delete(d.queues, key)
+			d.mutex.Unlock()
+			return
+		}
+		cmd := queue[0]
+		d.queues[key] = queue[1:]
+		queueDepth.WithLabelValues(queueTarget(key)).Dec()
+		d.mutex.Unlock()
+
+		d.workers <- struct{}{}
+		// commands still queued at shutdown run to completion within their
+		// own timeout rather than failing on the cancelled context
+		parent := cmd.ctx
+		if parent.Err() != nil {
+			parent = context.WithoutCancel(parent)
+		}
+		ctx, cancel := context.WithTimeout(parent, d.timeout)
+		err := cmd.run(ctx)
+		cancel()
+		<-d.workers
+
+		if cmd.done != nil {
+			cmd.done(err)
+		}
+		d.pending.Done()
+	}
+}
+
+// queueTarget returns the target part of a queue key such as "music/kitchen".
+func queueTarget(key string) string {
+	target, _, _ := strings.Cut(key, "/")
+	return target
+}
@@ -0,0 +1,153 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDispatcherKeepsOrderPerKey(t *testing.T) {
+	d := NewDispatcher(4, 100, time.Second)
+
+	var mutex sync.Mutex
+	var order []int
+	for i := 0; i < 20; i++ {
+		i := i
+		err := d.Submit(context.Background(), "music/kitchen", func(ctx context.Context) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			order = append(order, i)
+			return nil
+		}, nil)
+		if err != nil {
+			t.Fatalf("submit failed: %v", err)
+		}
+	}
+
+	if err := d.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, value := range order {
+		if value != i {
+			t.Fatalf("expected commands in submission order, got %v", order)
+		}
+	}
+}
+
+func TestDispatcherRunsKeysConcurrently(t *testing.T) {
+	d := NewDispatcher(2, 10, time.Second)
+
+	block := make(chan struct{})
+	d.Submit(context.Background(), "music/office", func(ctx context.Context) error {
+		<-block
+		return nil
+	}, nil)
+
+	done := make(chan error, 1)
+	d.Submit(context.Background(), "music/kitchen", func(ctx context.Context) error {
+		return nil
+	}, func(err error) {
+		done <- err
+	})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("command for another room was blocked by a slow command")
+	}
+	close(block)
+}
+
+func TestDispatcherLimitsQueueDepth(t *testing.T) {
+	d := NewDispatcher(1, 1, time.Second)
+
+	block := make(chan struct{})
+	run := func(ctx context.Context) error {
+		<-block
+		return nil
+	}
+
+	d.Submit(context.Background(), "light/hall", run, nil)
+	// wait for the first command to leave the queue
+	for d.Depth("light/hall") != 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := d.Submit(context.Background(), "light/hall", run, nil); err != nil {
+		t.Fatalf("expected second command to be queued, got %v", err)
+	}
+	if err := d.Submit(context.Background(), "light/hall", run, nil); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	close(block)
+}
+
+func TestDispatcherAppliesTimeout(t *testing.T) {
+	d := NewDispatcher(1, 1, 10*time.Millisecond)
+
+	done := make(chan error, 1)
+	d.Submit(context.Background(), "music/bath", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, func(err error) {
+		done <- err
+	})
+
+	if err := <-done; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestDispatcherRunsDrainedCommandsWithFreshContext(t *testing.T) {
+	d := NewDispatcher(1, 10, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var runErr error
+	err := d.Submit(ctx, "music/kitchen", func(ctx context.Context) error {
+		runErr = ctx.Err()
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+
+	if err := d.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if runErr != nil {
+		t.Fatalf("expected a live context, got %v", runErr)
+	}
+}
+
+func TestDispatcherLabelsQueueDepthByTarget(t *testing.T) {
+	d := NewDispatcher(1, 10, time.Second)
+
+	block := make(chan struct{})
+	run := func(ctx context.Context) error {
+		<-block
+		return nil
+	}
+	d.Submit(context.Background(), "blinds/kitchen", run, nil)
+	d.Submit(context.Background(), "blinds/kitchen", run, nil)
+	d.Submit(context.Background(), "blinds/office", run, nil)
+	for d.Depth("blinds/kitchen")+d.Depth("blinds/office") != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if depth := testutil.ToFloat64(queueDepth.WithLabelValues("blinds")); depth != 1 {
+		t.Fatalf("expected one waiting command for the target, got %v", depth)
+	}
+
+	close(block)
+	d.Wait(context.Background())
+	if depth := testutil.ToFloat64(queueDepth.WithLabelValues("blinds")); depth != 0 {
+		t.Fatalf("expected no waiting commands, got %v", depth)
+	}
+}
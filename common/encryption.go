@@ -0,0 +1,193 @@
+package common
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted files start with a header of the magic bytes and a format
+// version, followed by the scrypt salt, the GCM nonce and the sealed data.
+// Files without the header are legacy AES-CFB files using the raw key.
+const (
+	encryptionVersion = 1
+	saltSize          = 16
+	keySize           = 32
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+var (
+	encryptionMagic = []byte("CUORE")
+
+	derivedKeys     = map[[sha256.Size]byte][]byte{}
+	derivedKeyMutex sync.Mutex
+)
+
+var errLegacyFormat = errors.New("data is not in the versioned format")
+
+// deriveKey derives the AES key for a passphrase and salt. Derived keys are
+// cached, as scrypt is deliberately slow.
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	cacheKey := sha256.Sum256(append(append([]byte(passphrase), 0), salt...))
+
+	derivedKeyMutex.Lock()
+	defer derivedKeyMutex.Unlock()
+
+	if key, ok := derivedKeys[cacheKey]; ok {
+		return key, nil
+	}
+
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+
+	derivedKeys[cacheKey] = key
+	return key, nil
+}
+
+// encrypt encrypts data with AES-GCM using a key derived from passphrase.
+func encrypt(passphrase string, data []byte) ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	header := append(append([]byte{}, encryptionMagic...), encryptionVersion)
+	out := append(append([]byte{}, header...), salt...)
+	out = append(out, nonce...)
+
+	// the header is authenticated, so it cannot be swapped without notice
+	return gcm.Seal(out, nonce, data, header), nil
+}
+
+// decrypt decrypts data written by encrypt. It returns errLegacyFormat for
+// data without the versioned header.
+func decrypt(passphrase string, cipherText []byte) ([]byte, error) {
+	if !bytes.HasPrefix(cipherText, encryptionMagic) {
+		return nil, errLegacyFormat
+	}
+
+	headerSize := len(encryptionMagic) + 1
+	if len(cipherText) < headerSize+saltSize {
+		return nil, fmt.Errorf("cipherText too short")
+	}
+
+	header := cipherText[:headerSize]
+	if version := header[len(encryptionMagic)]; version != encryptionVersion {
+		return nil, fmt.Errorf("unsupported encryption version %d", version)
+	}
+
+	salt := cipherText[headerSize : headerSize+saltSize]
+	key, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	rest := cipherText[headerSize+saltSize:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, fmt.Errorf("cipherText too short")
+	}
+
+	data, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt, wrong key or corrupted data: %w", err)
+	}
+
+	return data, nil
+}
+
+// decryptLegacy decrypts data written before the versioned format, which
+// used AES-CFB with the raw passphrase as key.
+func decryptLegacy(passphrase string, cipherText []byte) ([]byte, error) {
+	block, err := aes.NewCipher([]byte(passphrase))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cipherText) < aes.BlockSize {
+		return nil, fmt.Errorf("cipherText too short")
+	}
+	iv := cipherText[:aes.BlockSize]
+	data := make([]byte, len(cipherText)-aes.BlockSize)
+
+	stream := cipher.NewCFBDecrypter(block, iv)
+	stream.XORKeyStream(data, cipherText[aes.BlockSize:])
+
+	return data, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it into place, so readers never see a partially written file. The
+// directory is created if it is missing.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,144 @@
+package common
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"cuore/config"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	data := []byte(`{"access_token":"secret"}`)
+
+	encrypted, err := encrypt("passphrase", data)
+	if err != nil {
+		t.Fatalf("encrypt failed: %v", err)
+	}
+
+	decrypted, err := decrypt("passphrase", encrypted)
+	if err != nil {
+		t.Fatalf("decrypt failed: %v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatalf("expected %s, got %s", data, decrypted)
+	}
+
+	if _, err := decrypt("wrong passphrase", encrypted); err == nil {
+		t.Fatal("expected decrypt with wrong passphrase to fail")
+	}
+
+	encrypted[len(encrypted)-1] ^= 0xff
+	if _, err := decrypt("passphrase", encrypted); err == nil {
+		t.Fatal("expected decrypt of tampered data to fail")
+	}
+}
+
+func TestFileStoreMigratesLegacyTokenFile(t *testing.T) {
+	dir := t.TempDir()
+	config.Get().EncryptionKey = "example key 1234"
+	store := newFileCredentialStore(dir)
+
+	jsonToken, _ := json.Marshal(oauth2.Token{AccessToken: "access", RefreshToken: "refresh"})
+	legacy := encryptLegacy(t, config.Get().EncryptionKey, jsonToken)
+	if err := os.WriteFile(filepath.Join(dir, "sonos"), legacy, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	key := CredentialKey{Provider: "sonos", Account: DefaultAccount, Name: tokenCredential}
+	value, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("failed to load legacy token: %v", err)
+	}
+
+	var token oauth2.Token
+	if err := json.Unmarshal(value, &token); err != nil || token.RefreshToken != "refresh" {
+		t.Fatalf("expected refresh token to be loaded, got %q (%v)", token.RefreshToken, err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "sonos")); !os.IsNotExist(err) {
+		t.Fatal("expected legacy token file to be removed")
+	}
+
+	migrated, _ := os.ReadFile(store.path(key))
+	if !bytes.HasPrefix(migrated, encryptionMagic) {
+		t.Fatal("expected token file to be migrated to the versioned format")
+	}
+
+	info, _ := os.Stat(store.path(key))
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestFileStoreKeepsLegacyTokenFileWithWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	store := newFileCredentialStore(dir)
+
+	jsonToken, _ := json.Marshal(oauth2.Token{AccessToken: "access", RefreshToken: "refresh"})
+	legacy := encryptLegacy(t, "another key 1234", jsonToken)
+	if err := os.WriteFile(filepath.Join(dir, "sonos"), legacy, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Get().EncryptionKey = "wrong key 123456"
+	defer func() { config.Get().EncryptionKey = "example key 1234" }()
+
+	key := CredentialKey{Provider: "sonos", Account: DefaultAccount, Name: tokenCredential}
+	if _, err := store.Get(key); err == nil {
+		t.Fatal("expected decrypting with the wrong key to fail")
+	}
+
+	if kept, _ := os.ReadFile(filepath.Join(dir, "sonos")); !bytes.Equal(kept, legacy) {
+		t.Fatal("expected legacy token file to be kept")
+	}
+	if _, err := os.Stat(store.path(key)); !os.IsNotExist(err) {
+		t.Fatal("expected no migrated token file")
+	}
+}
+
+func encryptLegacy(t *testing.T, key string, data []byte) []byte {
+	block, err := aes.NewCipher([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cipherText := make([]byte, aes.BlockSize+len(data))
+	iv := cipherText[:aes.BlockSize]
+	if _, err := rand.Read(iv); err != nil {
+		t.Fatal(err)
+	}
+
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(cipherText[aes.BlockSize:], data)
+	return cipherText
+}
+
+func TestFileStoreMigratesLegacyTokenFileWithFormerDefaultKey(t *testing.T) {
+	dir := t.TempDir()
+	store := newFileCredentialStore(dir)
+
+	jsonToken, _ := json.Marshal(oauth2.Token{AccessToken: "access", RefreshToken: "refresh"})
+	legacy := encryptLegacy(t, config.LegacyEncryptionKey, jsonToken)
+	if err := os.WriteFile(filepath.Join(dir, "sonos"), legacy, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Get().EncryptionKey = "a new secret key"
+	defer func() { config.Get().EncryptionKey = "example key 1234" }()
+
+	key := CredentialKey{Provider: "sonos", Account: DefaultAccount, Name: tokenCredential}
+	if _, err := store.Get(key); err != nil {
+		t.Fatalf("expected the token of the former default key to be migrated: %v", err)
+	}
+
+	migrated, _ := os.ReadFile(store.path(key))
+	if _, err := decrypt("a new secret key", migrated); err != nil {
+		t.Fatalf("expected the token to be encrypted with ENCRYPTION_KEY: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const (
+	HistoryKindControl = "control"
+	HistoryKindSetup   = "setup"
+
+	HistoryOutcomeSuccess   = "success"
+	HistoryOutcomeFailure   = "failure"
+	HistoryOutcomeRejected  = "rejected"
+	HistoryOutcomeDebounced = "debounced"
+	// a coalesced message was replaced by a later value before it was sent
+	HistoryOutcomeCoalesced = "coalesced"
+
+	HistorySourceMQTT = "mqtt"
+
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+	pruneInterval       = time.Hour
+)
+
+// HistorySource formats the source of a message, e.g. "mqtt:<topic>".
+func HistorySource(via string, identity string) string {
+	return via + ":" + identity
+}
+
+// HistoryRecord is one handled control or setup message.
+type HistoryRecord struct {
+	Id        int64     `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	// Source is where the message came from, see HistorySource
+	Source    string `json:"source"`
+	Kind      string `json:"kind"`
+	Target    string `json:"target"`
+	Room      string `json:"room,omitempty"`
+	Action    string `json:"action"`
+	Value     string `json:"value,omitempty"`
+	Outcome   string `json:"outcome"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// HistoryFilter selects records for Query. Empty fields match everything.
+type HistoryFilter struct {
+	Target string
+	Room   string
+	Action string
+	From   time.Time
+	To     time.Time
+	Limit  int
+}
+
+// HistoryStore records handled messages in a SQLite database, so it can be
+// traced later why something happened.
+type HistoryStore struct {
+	db *sql.DB
+}
+
+func OpenHistoryStore(path string) (*HistoryStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+	// records are written from concurrent commands
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS history (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp  INTEGER NOT NULL,
+		source     TEXT NOT NULL,
+		kind       TEXT NOT NULL,
+		target     TEXT NOT NULL,
+		room       TEXT NOT NULL,
+		action     TEXT NOT NULL,
+		value      TEXT NOT NULL,
+		outcome    TEXT NOT NULL,
+		error      TEXT NOT NULL,
+		latency_ms INTEGER NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS history_timestamp ON history (timestamp)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create history table: %w", err)
+	}
+
+	return &HistoryStore{db: db}, nil
+}
+
+func (s *HistoryStore) Record(record HistoryRecord) error {
+	_, err := s.db.Exec(
+		`INSERT INTO history (timestamp, source, kind, target, room, action, value, outcome, error, latency_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		record.Timestamp.UnixMilli(), record.Source, record.Kind, record.Target, record.Room,
+		record.Action, record.Value, record.Outcome, record.Error, record.LatencyMs,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record history: %w", err)
+	}
+	return nil
+}
+
+// Query returns the newest records matching filter first.
+func (s *HistoryStore) Query(filter HistoryFilter) ([]HistoryRecord, error) {
+	var conditions []string
+	var args []any
+
+	for column, value := range map[string]string{"target": filter.Target, "room": filter.Room, "action": filter.Action} {
+		if value != "" {
+			conditions = append(conditions, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "timestamp >= ?")
+		args = append(args, filter.From.UnixMilli())
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "timestamp < ?")
+		args = append(args, filter.To.UnixMilli())
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	query := `SELECT id, timestamp, source, kind, target, room, action, value, outcome, error, latency_ms FROM history`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY timestamp DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer rows.Close()
+
+	records := []HistoryRecord{}
+	for rows.Next() {
+		var record HistoryRecord
+		var timestamp int64
+		err := rows.Scan(&record.Id, &timestamp, &record.Source, &record.Kind, &record.Target, &record.Room,
+			&record.Action, &record.Value, &record.Outcome, &record.Error, &record.LatencyMs)
+		if err != nil {
+			return nil, err
+		}
+		record.Timestamp = time.UnixMilli(timestamp)
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// Prune deletes all records older than before.
+func (s *HistoryStore) Prune(before time.Time) (int64, error) {
+	result, err := s.db.Exec(`DELETE FROM history WHERE timestamp < ?`, before.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune history: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// RunRetention prunes records older than retention every hour until ctx is
+// cancelled. A retention of zero keeps records forever.
+func (s *HistoryStore) RunRetention(ctx context.Context, retention time.Duration) {
+	if retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if pruned, err := s.Prune(time.Now().Add(-retention)); err != nil {
+			slog.Error("Error pruning history", "error", err)
+		} else if pruned > 0 {
+			slog.Info("Pruned history", "records", pruned)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *HistoryStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryStoreQueryAndPrune(t *testing.T) {
+	store, err := OpenHistoryStore(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	records := []HistoryRecord{
+		{Timestamp: now.Add(-48 * time.Hour), Source: "control", Kind: HistoryKindControl, Target: "light", Room: "hall", Action: "on", Outcome: HistoryOutcomeSuccess},
+		{Timestamp: now.Add(-time.Hour), Source: "control", Kind: HistoryKindControl, Target: "light", Room: "hall", Action: "off", Outcome: HistoryOutcomeSuccess},
+		{Timestamp: now, Source: "control", Kind: HistoryKindControl, Target: "music", Room: "hall", Action: "play", Outcome: HistoryOutcomeFailure, Error: "unauthorized"},
+	}
+	for _, record := range records {
+		if err := store.Record(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, err := store.Query(HistoryFilter{Target: "light", From: now.Add(-2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].Action != "off" {
+		t.Fatalf("expected only the recent light record, got %+v", found)
+	}
+
+	pruned, err := store.Prune(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 {
+		t.Fatalf("expected one pruned record, got %d", pruned)
+	}
+
+	found, err = store.Query(HistoryFilter{Room: "hall"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0].Action != "play" || found[0].Error != "unauthorized" {
+		t.Fatalf("expected the newest record first, got %+v", found)
+	}
+}
@@ -0,0 +1,168 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+const (
+	traceIdKey    = "trace_id"
+	traceIdHeader = "X-Trace-Id"
+	redacted      = "[REDACTED]"
+)
+
+type traceIdContextKey struct{}
+
+// secretKeys are attribute keys whose values are never logged.
+var secretKeys = []string{"token", "secret", "password", "authorization", "key", "code_verifier"}
+
+// bearerPattern matches credentials that end up in messages or errors, e.g.
+// from an echoed Authorization header or a token endpoint response.
+var bearerPattern = regexp.MustCompile(`(?i)(bearer\s+|"?(access_token|refresh_token)"?\s*[:=]\s*"?)[A-Za-z0-9\-._~+/]+=*`)
+
+// SetupLogging makes slog the default logger with the given level (debug,
+// info, warn or error) and format (text or json). Trace ids are added from
+// the context and token material is redacted.
+func SetupLogging(level string, format string) {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		logLevel = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: logLevel, ReplaceAttr: redactAttr}
+
+	var handler slog.Handler
+	if strings.EqualFold(format, "json") {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+
+	slog.SetDefault(slog.New(traceHandler{handler}))
+}
+
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if token, ok := attr.Value.Any().(*oauth2.Token); ok {
+		return slog.Any(attr.Key, RedactToken(token))
+	}
+
+	if attr.Value.Kind() != slog.KindString {
+		if err, ok := attr.Value.Any().(error); ok {
+			return slog.String(attr.Key, redactString(err.Error()))
+		}
+		return attr
+	}
+
+	key := strings.ToLower(attr.Key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return slog.String(attr.Key, redacted)
+		}
+	}
+
+	return slog.String(attr.Key, redactString(attr.Value.String()))
+}
+
+// secretValues holds credentials known to the process, which are redacted
+// wherever they appear, e.g. the Hue application key in request paths.
+var (
+	secretValues      = map[string]bool{}
+	secretValuesMutex sync.RWMutex
+)
+
+// RegisterSecret makes sure value never shows up in the logs.
+func RegisterSecret(value string) {
+	// short values would redact unrelated text
+	if len(value) < 8 {
+		return
+	}
+
+	secretValuesMutex.Lock()
+	defer secretValuesMutex.Unlock()
+
+	secretValues[value] = true
+}
+
+func redactString(value string) string {
+	secretValuesMutex.RLock()
+	for secret := range secretValues {
+		value = strings.ReplaceAll(value, secret, redacted)
+	}
+	secretValuesMutex.RUnlock()
+
+	return bearerPattern.ReplaceAllString(value, "${1}"+redacted)
+}
+
+// traceHandler adds the trace id of the context to every record.
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, record slog.Record) error {
+	if traceId := TraceId(ctx); traceId != "" {
+		record.AddAttrs(slog.String(traceIdKey, traceId))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
+
+// WithTraceId returns a context carrying a new trace id, which is logged with
+// every record of the command it belongs to.
+func WithTraceId(ctx context.Context) context.Context {
+	return context.WithValue(ctx, traceIdContextKey{}, newTraceId())
+}
+
+// TraceId returns the trace id of ctx or an empty string.
+func TraceId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	traceId, _ := ctx.Value(traceIdContextKey{}).(string)
+	return traceId
+}
+
+func newTraceId() string {
+	bytes := make([]byte, 8)
+	if _, err := rand.Read(bytes); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// RequestLogger is the gin middleware that gives every request a trace id,
+// returns it in the X-Trace-Id header and logs the request once handled.
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := WithTraceId(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		c.Header(traceIdHeader, TraceId(ctx))
+
+		start := time.Now()
+		c.Next()
+
+		// the query may contain an authorization code
+		slog.InfoContext(ctx, "Handled request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+		)
+	}
+}
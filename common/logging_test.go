@@ -0,0 +1,32 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLoggingRedactsTokensAndAddsTraceId(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(traceHandler{slog.NewJSONHandler(&out, &slog.HandlerOptions{ReplaceAttr: redactAttr})})
+
+	RegisterSecret("application-key-1234")
+	ctx := WithTraceId(context.Background())
+	logger.InfoContext(ctx, "Request failed",
+		"access_token", "secret-value",
+		"error", errors.New("GET /api/application-key-1234/groups: Authorization: Bearer abc.def"),
+	)
+
+	logged := out.String()
+	for _, secret := range []string{"secret-value", "application-key-1234", "abc.def"} {
+		if strings.Contains(logged, secret) {
+			t.Fatalf("secret %q was logged: %s", secret, logged)
+		}
+	}
+	if !strings.Contains(logged, TraceId(ctx)) {
+		t.Fatalf("trace id missing: %s", logged)
+	}
+}
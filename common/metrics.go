@@ -0,0 +1,98 @@
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "cuore"
+
+var (
+	// MQTTMessagesReceived counts incoming messages by topic and whether
+	// they could be decoded and queued.
+	MQTTMessagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "mqtt_messages_received_total",
+		Help:      "MQTT messages received by topic and outcome.",
+	}, []string{"topic", "outcome"})
+
+	// CommandsTotal counts control commands by target, action and result.
+	CommandsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "commands_total",
+		Help:      "Commands handled by target, action and result.",
+	}, []string{"target", "action", "result"})
+
+	// CommandDuration measures how long integrations take to run a command.
+	CommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "command_duration_seconds",
+		Help:      "Time to run a command by target and action.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"target", "action"})
+
+	vendorRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "vendor_request_duration_seconds",
+		Help:      "Latency of vendor API requests by integration, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"integration", "method", "status"})
+
+	tokenRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "token_refreshes_total",
+		Help:      "OAuth token refreshes by provider and result.",
+	}, []string{"provider", "result"})
+
+	queueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "command_queue_depth",
+		Help:      "Commands waiting in the dispatcher queues by target.",
+	}, []string{"target"})
+)
+
+// stateSyncs remembers when each integration last synced its state with the
+// vendor, and reports the age of that sync on every scrape.
+var stateSyncs = &stateSyncCollector{
+	desc: prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "state_sync_age_seconds"),
+		"Seconds since the integration last synced its state with the vendor.",
+		[]string{"integration"}, nil,
+	),
+	synced: map[string]time.Time{},
+}
+
+func init() {
+	prometheus.MustRegister(stateSyncs)
+}
+
+type stateSyncCollector struct {
+	desc *prometheus.Desc
+
+	mutex  sync.Mutex
+	synced map[string]time.Time
+}
+
+func (c *stateSyncCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *stateSyncCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for integration, synced := range c.synced {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Since(synced).Seconds(), integration)
+	}
+}
+
+// RecordStateSync marks the state of integration as freshly synced.
+func RecordStateSync(integration string) {
+	stateSyncs.mutex.Lock()
+	defer stateSyncs.mutex.Unlock()
+
+	stateSyncs.synced[integration] = time.Now()
+}
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const authStateTTL = 10 * time.Minute
+
+type authState struct {
+	provider string
+	verifier string
+	expiry   time.Time
+}
+
+var (
+	authStates     = map[string]authState{}
+	authStateMutex sync.Mutex
+)
+
+// NewAuthState starts an authorization flow for provider. It returns a random
+// state that has to be passed to AuthCodeURL together with the returned
+// options, which carry the PKCE challenge when pkce is set.
+func NewAuthState(provider string, pkce bool) (string, []oauth2.AuthCodeOption, error) {
+	state, err := randomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var opts []oauth2.AuthCodeOption
+	var verifier string
+	if pkce {
+		verifier, err = randomString(32)
+		if err != nil {
+			return "", nil, err
+		}
+
+		challenge := sha256.Sum256([]byte(verifier))
+		opts = append(opts,
+			oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		)
+	}
+
+	authStateMutex.Lock()
+	defer authStateMutex.Unlock()
+
+	removeExpiredAuthStates()
+	authStates[state] = authState{
+		provider: provider,
+		verifier: verifier,
+		expiry:   time.Now().Add(authStateTTL),
+	}
+
+	return state, opts, nil
+}
+
+// ConsumeAuthState checks the state returned to the callback of provider. A
+// state can only be used once. The returned options carry the PKCE verifier
+// and have to be passed to Exchange.
+func ConsumeAuthState(provider string, state string) ([]oauth2.AuthCodeOption, error) {
+	authStateMutex.Lock()
+	defer authStateMutex.Unlock()
+
+	removeExpiredAuthStates()
+	stored, ok := authStates[state]
+	if !ok {
+		return nil, fmt.Errorf("unknown or expired state")
+	}
+
+	delete(authStates, state)
+	if stored.provider != provider {
+		return nil, fmt.Errorf("state was issued for a different provider")
+	}
+
+	if stored.verifier == "" {
+		return nil, nil
+	}
+	return []oauth2.AuthCodeOption{oauth2.SetAuthURLParam("code_verifier", stored.verifier)}, nil
+}
+
+// removeExpiredAuthStates must be called with authStateMutex held.
+func removeExpiredAuthStates() {
+	now := time.Now()
+	for key, stored := range authStates {
+		if now.After(stored.expiry) {
+			delete(authStates, key)
+		}
+	}
+}
+
+func randomString(length int) (string, error) {
+	bytes := make([]byte, length)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random string: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
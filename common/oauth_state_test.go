@@ -0,0 +1,56 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestAuthStateIsSingleUseAndPerProvider(t *testing.T) {
+	state, _, err := NewAuthState("sonos", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ConsumeAuthState("hue", state); err == nil {
+		t.Fatal("expected a state of another provider to be rejected")
+	}
+
+	state, _, _ = NewAuthState("sonos", false)
+	if _, err := ConsumeAuthState("sonos", state); err != nil {
+		t.Fatalf("expected the state to be accepted: %v", err)
+	}
+	if _, err := ConsumeAuthState("sonos", state); err == nil {
+		t.Fatal("expected a used state to be rejected")
+	}
+	if _, err := ConsumeAuthState("sonos", "state"); err == nil {
+		t.Fatal("expected an unknown state to be rejected")
+	}
+}
+
+func TestAuthStateCarriesPKCEVerifier(t *testing.T) {
+	state, opts, err := NewAuthState("hue", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authConfig := &oauth2.Config{Endpoint: oauth2.Endpoint{AuthURL: "https://auth.example/authorize"}}
+	authURL, _ := url.Parse(authConfig.AuthCodeURL(state, opts...))
+	challenge := authURL.Query().Get("code_challenge")
+
+	exchangeOpts, err := ConsumeAuthState("hue", state)
+	if err != nil || len(exchangeOpts) != 1 {
+		t.Fatalf("expected the PKCE verifier, got %v (%v)", exchangeOpts, err)
+	}
+
+	// the verifier only reaches the token request, so read it back from there
+	verifierURL, _ := url.Parse(authConfig.AuthCodeURL("", exchangeOpts...))
+	verifier := verifierURL.Query().Get("code_verifier")
+	sum := sha256.Sum256([]byte(verifier))
+	if challenge == "" || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+		t.Fatal("expected the challenge to be derived from the verifier")
+	}
+}
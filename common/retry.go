@@ -0,0 +1,40 @@
+package common
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	retryInitialDelay = time.Second
+	retryMaxDelay     = time.Minute
+)
+
+// RetryUntilSuccess calls attempt until it succeeds or ctx is cancelled,
+// doubling the delay between attempts up to a minute. Each attempt gets the
+// command timeout.
+func RetryUntilSuccess(ctx context.Context, name string, timeout time.Duration, attempt func(ctx context.Context) error) {
+	delay := retryInitialDelay
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := attempt(attemptCtx)
+		cancel()
+
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		slog.WarnContext(ctx, "Retrying after error", "operation", name, "retry_in", delay, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}
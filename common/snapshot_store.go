@@ -0,0 +1,110 @@
+package common
+
+import (
+	"cuore/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const DefaultSnapshotName = "default"
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// snapshots holds the named room snapshots of all integrations, keyed by
+// target, room and name. With SNAPSHOT_FILE_PATH set, they are also written
+// to that file and survive a restart.
+var snapshots = struct {
+	mutex  sync.Mutex
+	loaded bool
+	values map[string]json.RawMessage
+}{values: map[string]json.RawMessage{}}
+
+func snapshotKey(target string, room string, name string) string {
+	if name == "" {
+		name = DefaultSnapshotName
+	}
+	return target + "/" + room + "/" + name
+}
+
+// SaveSnapshot stores the snapshot of a room under name, replacing an
+// earlier one with the same name.
+func SaveSnapshot(target string, room string, name string, snapshot any) error {
+	value, err := json.Marshal(snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	snapshots.mutex.Lock()
+	defer snapshots.mutex.Unlock()
+
+	if err := loadSnapshots(); err != nil {
+		return err
+	}
+
+	snapshots.values[snapshotKey(target, room, name)] = value
+	return persistSnapshots()
+}
+
+// LoadSnapshot decodes the snapshot of a room stored under name into
+// snapshot.
+func LoadSnapshot(target string, room string, name string, snapshot any) error {
+	snapshots.mutex.Lock()
+	defer snapshots.mutex.Unlock()
+
+	if err := loadSnapshots(); err != nil {
+		return err
+	}
+
+	value, ok := snapshots.values[snapshotKey(target, room, name)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSnapshotNotFound, snapshotKey(target, room, name))
+	}
+
+	if err := json.Unmarshal(value, snapshot); err != nil {
+		return fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+	return nil
+}
+
+// loadSnapshots reads the persisted snapshots on first use. It must be
+// called with the mutex held.
+func loadSnapshots() error {
+	path := config.Get().SnapshotFilePath
+	if snapshots.loaded || path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read snapshots: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &snapshots.values); err != nil {
+			return fmt.Errorf("failed to decode snapshots: %w", err)
+		}
+	}
+
+	snapshots.loaded = true
+	return nil
+}
+
+// persistSnapshots must be called with the mutex held.
+func persistSnapshots() error {
+	path := config.Get().SnapshotFilePath
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(snapshots.values)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshots: %w", err)
+	}
+
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write snapshots: %w", err)
+	}
+	return nil
+}
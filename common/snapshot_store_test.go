@@ -0,0 +1,37 @@
+package common
+
+import (
+	"cuore/config"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotsArePersisted(t *testing.T) {
+	config.Get().SnapshotFilePath = filepath.Join(t.TempDir(), "snapshots.json")
+	defer func() { config.Get().SnapshotFilePath = "" }()
+
+	type roomState struct{ Volume int }
+	if err := SaveSnapshot("music", "kitchen", "doorbell", roomState{Volume: 25}); err != nil {
+		t.Fatal(err)
+	}
+
+	// forget the snapshots held in memory
+	snapshots.mutex.Lock()
+	snapshots.loaded = false
+	snapshots.values = map[string]json.RawMessage{}
+	snapshots.mutex.Unlock()
+
+	var restored roomState
+	if err := LoadSnapshot("music", "kitchen", "doorbell", &restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Volume != 25 {
+		t.Fatalf("expected volume 25, got %d", restored.Volume)
+	}
+
+	if err := LoadSnapshot("music", "kitchen", "alarm", &restored); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Fatalf("expected ErrSnapshotNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// statePublisher publishes retained state messages, set once connected to
+// the MQTT broker.
+var statePublisher struct {
+	mutex   sync.RWMutex
+	publish func(topic string, payload []byte)
+}
+
+// SetStatePublisher sets the function publishing state messages.
+func SetStatePublisher(publish func(topic string, payload []byte)) {
+	statePublisher.mutex.Lock()
+	defer statePublisher.mutex.Unlock()
+
+	statePublisher.publish = publish
+}
+
+// StateTopic returns the topic the state of a room is published to. Room
+// names come from vendor APIs and messages, so names that would change the
+// levels of the topic or contain wildcards are rejected.
+func StateTopic(target string, room string, name string) (string, error) {
+	if room == "" || strings.ContainsAny(room, "/+#\x00") {
+		return "", fmt.Errorf("room name %q cannot be used in a topic", room)
+	}
+	return "cuore/state/" + target + "/" + room + "/" + name, nil
+}
+
+// PublishState publishes value as JSON to the state topic of a room. It is a
+// no-op until a publisher is set.
+func PublishState(ctx context.Context, target string, room string, name string, value any) {
+	statePublisher.mutex.RLock()
+	publish := statePublisher.publish
+	statePublisher.mutex.RUnlock()
+
+	if publish == nil {
+		return
+	}
+
+	topic, err := StateTopic(target, room, name)
+	if err != nil {
+		slog.WarnContext(ctx, "Not publishing state", "target", target, "state", name, "error", err)
+		return
+	}
+
+	payload, err := json.Marshal(value)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error encoding state", "target", target, "room", room, "state", name, "error", err)
+		return
+	}
+	publish(topic, payload)
+}
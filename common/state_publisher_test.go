@@ -0,0 +1,15 @@
+package common
+
+import "testing"
+
+func TestStateTopicRejectsTopicLevels(t *testing.T) {
+	if topic, err := StateTopic("music", "Living Room", "now-playing"); err != nil || topic != "cuore/state/music/Living Room/now-playing" {
+		t.Fatalf("expected the state topic of the room, got %q, %v", topic, err)
+	}
+
+	for _, room := range []string{"", "Kitchen/Left", "Kitchen+", "#"} {
+		if _, err := StateTopic("music", room, "now-playing"); err == nil {
+			t.Errorf("expected room %q to be rejected", room)
+		}
+	}
+}
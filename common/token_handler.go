@@ -1,127 +1,89 @@
 package common
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"cuore/config"
 	"encoding/json"
 	"fmt"
-	"io"
-	"log"
-	"os"
+	"time"
 
 	"golang.org/x/oauth2"
 )
 
-var (
-	authData = map[string]*oauth2.Token{}
-)
-
-func GetTokenForProvider(provider string) (*oauth2.Token, error) {
-	if token, ok := authData[provider]; ok {
-		return token, nil
-	}
-
-	token, err := LoadTokenFromFile(provider)
-	if err != nil {
-		return nil, err
-	}
-
-	return token, nil
-}
-
-func SaveTokenForProvider(provider string, token *oauth2.Token) error {
-	authData[provider] = token
-
-	err := SaveTokenToFile(provider, token)
-
-	if err != nil {
-		return err
-	}
-
-	return nil
+// TokenStatus describes a token without exposing any token material, so it
+// can be returned to API clients.
+type TokenStatus struct {
+	Authorized  bool      `json:"authorized"`
+	Expiry      time.Time `json:"expiry,omitempty"`
+	Refreshable bool      `json:"refreshable"`
 }
 
-func SaveTokenToFile(filename string, oauthToken *oauth2.Token) error {
-	jsonToken, err := json.Marshal(oauthToken)
-	if err != nil {
-		return err
-	}
-
-	log.Print("Encrypting token")
-	encryptedToken, err := encrypt(jsonToken)
-	if err != nil {
-		return err
+func RedactToken(token *oauth2.Token) TokenStatus {
+	if token == nil {
+		return TokenStatus{}
 	}
 
-	log.Printf("Saving token to file: %s", filename)
-	fullPath := fmt.Sprintf("%s/%s", config.Get().EncryptionFilePath, filename)
-	err = os.WriteFile(fullPath, encryptedToken, 0644)
-	if err != nil {
-		return err
+	return TokenStatus{
+		Authorized:  token.Valid(),
+		Expiry:      token.Expiry,
+		Refreshable: token.RefreshToken != "",
 	}
-
-	return nil
 }
 
-func LoadTokenFromFile(filename string) (*oauth2.Token, error) {
-	log.Printf("Loading token from file: %s", filename)
-	fullPath := fmt.Sprintf("%s/%s", config.Get().EncryptionFilePath, filename)
-	encryptedToken, err := os.ReadFile(fullPath)
+// GetToken returns the OAuth token of an account, or ErrCredentialNotFound
+// if the account has not been authorized yet.
+func GetToken(provider string, account string) (*oauth2.Token, error) {
+	store, err := OpenCredentialStore()
 	if err != nil {
-		log.Fatalf("Error reading token from file: %v", err)
 		return nil, err
 	}
 
-	decryptedToken, err := decrypt(encryptedToken)
+	value, err := store.Get(CredentialKey{Provider: provider, Account: account, Name: tokenCredential})
 	if err != nil {
 		return nil, err
 	}
 
 	var token oauth2.Token
-	err = json.Unmarshal(decryptedToken, &token)
-	if err != nil {
-		return nil, err
+	if err := json.Unmarshal(value, &token); err != nil {
+		return nil, fmt.Errorf("failed to decode token: %w", err)
 	}
 
 	return &token, nil
 }
 
-// encrypt encrypts data using AES encryption.
-func encrypt(data []byte) ([]byte, error) {
-	block, err := aes.NewCipher([]byte(config.Get().EncryptionKey))
+func SaveToken(provider string, account string, token *oauth2.Token) error {
+	store, err := OpenCredentialStore()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	cipherText := make([]byte, aes.BlockSize+len(data))
-	iv := cipherText[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return nil, err
+	value, err := json.Marshal(token)
+	if err != nil {
+		return err
 	}
 
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(cipherText[aes.BlockSize:], data)
-
-	return cipherText, nil
+	return store.Put(CredentialKey{Provider: provider, Account: account, Name: tokenCredential}, value)
 }
 
-// decrypt decrypts data using AES decryption.
-func decrypt(cipherText []byte) ([]byte, error) {
-	block, err := aes.NewCipher([]byte(config.Get().EncryptionKey))
+// GetSecret returns a secret other than an OAuth token, or
+// ErrCredentialNotFound if it has not been stored yet.
+func GetSecret(provider string, account string, name string) (string, error) {
+	store, err := OpenCredentialStore()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	if len(cipherText) < aes.BlockSize {
-		return nil, fmt.Errorf("cipherText too short")
+	value, err := store.Get(CredentialKey{Provider: provider, Account: account, Name: name})
+	if err != nil {
+		return "", err
 	}
-	iv := cipherText[:aes.BlockSize]
-	cipherText = cipherText[aes.BlockSize:]
 
-	stream := cipher.NewCFBDecrypter(block, iv)
-	stream.XORKeyStream(cipherText, cipherText)
+	return string(value), nil
+}
+
+func SaveSecret(provider string, account string, name string, value string) error {
+	store, err := OpenCredentialStore()
+	if err != nil {
+		return err
+	}
 
-	return cipherText, nil
+	return store.Put(CredentialKey{Provider: provider, Account: account, Name: name}, []byte(value))
 }
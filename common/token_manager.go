@@ -0,0 +1,279 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+type AuthState string
+
+const (
+	AuthStateUnknown      AuthState = "unknown"
+	AuthStateAuthorized   AuthState = "authorized"
+	AuthStateUnauthorized AuthState = "unauthorized"
+)
+
+const (
+	// tokens are renewed this long before they expire
+	refreshLeeway   = 5 * time.Minute
+	refreshInterval = time.Minute
+	refreshTimeout  = 30 * time.Second
+)
+
+// TokenManager is the shared token source for one provider account. It
+// loads the token from the credential store, renews it before it expires and
+// tracks whether the account is still authorized.
+type TokenManager struct {
+	provider   string
+	account    func() string
+	authConfig func() *oauth2.Config
+
+	mutex     sync.Mutex
+	token     *oauth2.Token
+	state     AuthState
+	lastError error
+	// inflight is the refresh in progress, which concurrent callers wait for
+	// instead of holding the mutex during the request
+	inflight *refreshCall
+}
+
+type refreshCall struct {
+	done  chan struct{}
+	token *oauth2.Token
+	err   error
+}
+
+// NewTokenManager creates a token manager. account and authConfig are
+// resolved on use, as the config is loaded after package initialization.
+func NewTokenManager(provider string, account func() string, authConfig func() *oauth2.Config) *TokenManager {
+	return &TokenManager{
+		provider:   provider,
+		account:    account,
+		authConfig: authConfig,
+		state:      AuthStateUnknown,
+	}
+}
+
+// Token returns a valid token, refreshing it if it has expired.
+func (m *TokenManager) Token(ctx context.Context) (*oauth2.Token, error) {
+	m.mutex.Lock()
+	if err := m.load(); err != nil {
+		m.mutex.Unlock()
+		return nil, err
+	}
+	if m.token.Valid() {
+		token := m.token
+		m.mutex.Unlock()
+		return token, nil
+	}
+	m.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+
+	return m.refresh(ctx)
+}
+
+// TokenSource adapts the manager to oauth2.TokenSource, e.g. for
+// oauth2.NewClient. Tokens are loaded and refreshed with ctx.
+func (m *TokenManager) TokenSource(ctx context.Context) oauth2.TokenSource {
+	return tokenSource{manager: m, ctx: ctx}
+}
+
+type tokenSource struct {
+	manager *TokenManager
+	ctx     context.Context
+}
+
+func (s tokenSource) Token() (*oauth2.Token, error) {
+	return s.manager.Token(s.ctx)
+}
+
+// SetToken stores a token obtained through the authorization flow.
+func (m *TokenManager) SetToken(token *oauth2.Token) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := SaveToken(m.provider, m.account(), token); err != nil {
+		return err
+	}
+
+	m.token = token
+	registerToken(token)
+	m.setState(AuthStateAuthorized, nil)
+	return nil
+}
+
+// Refresh renews the token regardless of its expiry.
+func (m *TokenManager) Refresh(ctx context.Context) (*oauth2.Token, error) {
+	return m.refresh(ctx)
+}
+
+// State reports whether the account is authorized and the error that caused
+// the last failed load or refresh.
+func (m *TokenManager) State() (AuthState, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.state, m.lastError
+}
+
+// Run renews the token shortly before it expires until ctx is cancelled.
+func (m *TokenManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		m.refreshIfExpiring(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *TokenManager) refreshIfExpiring(ctx context.Context) {
+	m.mutex.Lock()
+	if err := m.load(); err != nil {
+		m.mutex.Unlock()
+		return
+	}
+	expiry := m.token.Expiry
+	m.mutex.Unlock()
+
+	if expiry.IsZero() || time.Until(expiry) > refreshLeeway {
+		return
+	}
+
+	refreshCtx, cancel := context.WithTimeout(ctx, refreshTimeout)
+	defer cancel()
+
+	if _, err := m.refresh(refreshCtx); err != nil {
+		slog.ErrorContext(ctx, "Error refreshing token", "provider", m.provider, "error", err)
+	}
+}
+
+// load reads the token from the credential store on first use. It must be
+// called with the mutex held.
+func (m *TokenManager) load() error {
+	if m.token != nil {
+		return nil
+	}
+
+	token, err := GetToken(m.provider, m.account())
+	if errors.Is(err, ErrCredentialNotFound) {
+		err = fmt.Errorf("%s has not been authorized yet", m.provider)
+		m.setState(AuthStateUnauthorized, err)
+		return err
+	}
+	if err != nil {
+		m.setState(m.state, err)
+		return err
+	}
+
+	m.token = token
+	registerToken(token)
+	m.setState(AuthStateAuthorized, nil)
+	return nil
+}
+
+// refresh renews the token. Concurrent calls share a single request, which
+// is sent without holding the mutex.
+func (m *TokenManager) refresh(ctx context.Context) (*oauth2.Token, error) {
+	m.mutex.Lock()
+	if err := m.load(); err != nil {
+		m.mutex.Unlock()
+		return nil, err
+	}
+
+	if call := m.inflight; call != nil {
+		m.mutex.Unlock()
+		select {
+		case <-call.done:
+			return call.token, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if m.token.RefreshToken == "" {
+		err := fmt.Errorf("%s token expired and has no refresh token", m.provider)
+		m.setState(AuthStateUnauthorized, err)
+		m.mutex.Unlock()
+		return nil, err
+	}
+
+	call := &refreshCall{done: make(chan struct{})}
+	m.inflight = call
+	refreshToken := m.token.RefreshToken
+	m.mutex.Unlock()
+
+	call.token, call.err = m.exchange(ctx, refreshToken)
+
+	m.mutex.Lock()
+	m.inflight = nil
+	m.mutex.Unlock()
+	close(call.done)
+
+	return call.token, call.err
+}
+
+// exchange sends the refresh request and stores the new token.
+func (m *TokenManager) exchange(ctx context.Context, refreshToken string) (*oauth2.Token, error) {
+	slog.InfoContext(ctx, "Refreshing token", "provider", m.provider)
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Timeout: refreshTimeout})
+
+	// without an access token the token source always refreshes
+	token, err := m.authConfig().TokenSource(ctx, &oauth2.Token{RefreshToken: refreshToken}).Token()
+	if err != nil {
+		tokenRefreshes.WithLabelValues(m.provider, "failure").Inc()
+
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.Response.StatusCode >= 400 && retrieveErr.Response.StatusCode < 500 {
+			// the refresh token has been revoked or is invalid
+			m.setState(AuthStateUnauthorized, err)
+		} else {
+			m.setState(m.state, err)
+		}
+		return nil, err
+	}
+
+	tokenRefreshes.WithLabelValues(m.provider, "success").Inc()
+
+	if err := SaveToken(m.provider, m.account(), token); err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.token = token
+	registerToken(token)
+	m.setState(AuthStateAuthorized, nil)
+	return token, nil
+}
+
+func registerToken(token *oauth2.Token) {
+	RegisterSecret(token.AccessToken)
+	RegisterSecret(token.RefreshToken)
+}
+
+func (m *TokenManager) setState(state AuthState, err error) {
+	if state != m.state {
+		slog.Info("Authorization state changed", "provider", m.provider, "state", state)
+	}
+	m.state = state
+	m.lastError = err
+}
@@ -0,0 +1,40 @@
+package common
+
+import (
+	"context"
+	"cuore/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestTokenSourceAuthorizesClient(t *testing.T) {
+	config.Get().CredentialStore = "file"
+	config.Get().EncryptionFilePath = t.TempDir()
+	config.Get().EncryptionKey = "example key 1234"
+
+	manager := NewTokenManager("test", func() string { return DefaultAccount }, func() *oauth2.Config { return &oauth2.Config{} })
+	if err := manager.SetToken(&oauth2.Token{AccessToken: "access", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	response, err := oauth2.NewClient(ctx, manager.TokenSource(ctx)).Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if authorization != "Bearer access" {
+		t.Fatalf("expected the token of the manager, got %q", authorization)
+	}
+}
@@ -3,8 +3,13 @@ package common
 type ControlMessage struct {
 	Target string `json:"target"` // e.g. "music", "light"
 	Room   string `json:"room"`
-	Action string `json:"action"`          // e.g. "play", "pause", "volume"
-	Value  *int   `json:"value,omitempty"` // optional, used for volume
+	Action string `json:"action"`           // e.g. "play", "pause", "volume"
+	Value  *int   `json:"value,omitempty"`  // optional, used for volume, sleep minutes and home-theater settings
+	Name   string `json:"name,omitempty"`   // optional, snapshot name for "snapshot" and "restore", preset for "group-preset"
+	To     string `json:"to,omitempty"`     // optional, destination room for "transfer", room or group for "join"
+	URL    string `json:"url,omitempty"`    // optional, stream for "play-url"
+	Title  string `json:"title,omitempty"`  // optional, display title for "play-url"
+	Artist string `json:"artist,omitempty"` // optional, display artist for "play-url"
 }
 
 type SetupMessage struct {
@@ -0,0 +1,186 @@
+package common
+
+import (
+	"context"
+	"cuore/config"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// APIError is returned for requests a vendor API answered with an error.
+type APIError struct {
+	Vendor     string
+	Method     string
+	Path       string
+	StatusCode int
+	// Message is the error reported in the response body, if any
+	Message string
+	// RetryAfter is the delay requested by a rate-limited response
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s API %s %s failed with status %d: %s", e.Vendor, e.Method, e.Path, e.StatusCode, message)
+}
+
+// Retryable reports whether the request may succeed when sent again.
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// VendorClient sends requests to a vendor API. Every attempt has its own
+// timeout, and requests failing with a server error, a rate limit or a reset
+// connection are retried with exponential backoff and jitter.
+type VendorClient struct {
+	vendor string
+	client *http.Client
+
+	// ErrorMessage extracts the vendor's message from an error response body.
+	ErrorMessage func(body []byte) string
+	// CheckBody reports errors a vendor returns with a successful status.
+	CheckBody func(res *http.Response, body []byte) error
+}
+
+func NewVendorClient(vendor string) *VendorClient {
+	return &VendorClient{
+		vendor: vendor,
+		client: &http.Client{},
+	}
+}
+
+// Do sends req and returns the body of a successful response. The body of
+// req is replayed through GetBody on retries, which http.NewRequest sets up
+// for the usual readers.
+func (c *VendorClient) Do(req *http.Request) ([]byte, error) {
+	maxRetries := config.Get().VendorMaxRetries
+
+	for attempt := 0; ; attempt++ {
+		body, err := c.do(req)
+		if err == nil || attempt >= maxRetries || !retryable(err) || req.Context().Err() != nil {
+			return body, err
+		}
+
+		delay := backoff(attempt)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+
+		slog.WarnContext(req.Context(), "Retrying vendor API request", "vendor", c.vendor, "retry_in", delay.Round(time.Millisecond), "error", err)
+		select {
+		case <-req.Context().Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c *VendorClient) do(req *http.Request) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), config.Get().VendorRequestTimeout)
+	defer cancel()
+
+	attempt := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("failed to replay request body: %w", err)
+		}
+		attempt.Body = body
+	}
+
+	start := time.Now()
+	res, err := c.client.Do(attempt)
+	if err != nil {
+		vendorRequestDuration.WithLabelValues(strings.ToLower(c.vendor), req.Method, "error").Observe(time.Since(start).Seconds())
+		return nil, fmt.Errorf("failed to make request to %s API: %w", c.vendor, err)
+	}
+	defer res.Body.Close()
+	vendorRequestDuration.WithLabelValues(strings.ToLower(c.vendor), req.Method, strconv.Itoa(res.StatusCode)).Observe(time.Since(start).Seconds())
+	slog.DebugContext(req.Context(), "Vendor API request",
+		"vendor", c.vendor,
+		"method", req.Method,
+		"path", req.URL.Path,
+		"status", res.StatusCode,
+		"duration", time.Since(start),
+	)
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s API response: %w", c.vendor, err)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		apiErr := &APIError{
+			Vendor:     c.vendor,
+			Method:     req.Method,
+			Path:       req.URL.Path,
+			StatusCode: res.StatusCode,
+			RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
+		if c.ErrorMessage != nil {
+			apiErr.Message = c.ErrorMessage(body)
+		}
+		return nil, apiErr
+	}
+
+	if c.CheckBody != nil {
+		if err := c.CheckBody(res, body); err != nil {
+			return nil, err
+		}
+	}
+
+	return body, nil
+}
+
+// retryable classifies errors that are worth another attempt.
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	// the timeout of a single attempt, not of the whole request
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// backoff returns the delay before the retry following attempt, doubling
+// from the base delay up to the maximum with full jitter.
+func backoff(attempt int) time.Duration {
+	delay := config.Get().VendorRetryBaseDelay << attempt
+	if maxDelay := config.Get().VendorRetryMaxDelay; delay > maxDelay || delay <= 0 {
+		delay = maxDelay
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as an HTTP date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
@@ -0,0 +1,84 @@
+package common
+
+import (
+	"cuore/config"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupVendorConfig() {
+	config.Get().VendorRequestTimeout = time.Second
+	config.Get().VendorMaxRetries = 3
+	config.Get().VendorRetryBaseDelay = time.Millisecond
+	config.Get().VendorRetryMaxDelay = 10 * time.Millisecond
+}
+
+func TestVendorClientRetriesServerErrors(t *testing.T) {
+	setupVendorConfig()
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if body, _ := io.ReadAll(r.Body); string(body) != `{"volume":10}` {
+			t.Errorf("request body not replayed, got %q", body)
+		}
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest("POST", server.URL, strings.NewReader(`{"volume":10}`))
+	body, err := NewVendorClient("Test").Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "ok" || attempts != 3 {
+		t.Fatalf("expected success after 3 attempts, got %q after %d", body, attempts)
+	}
+}
+
+func TestVendorClientReturnsAPIError(t *testing.T) {
+	setupVendorConfig()
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid volume"))
+	}))
+	defer server.Close()
+
+	client := NewVendorClient("Test")
+	client.ErrorMessage = func(body []byte) string { return string(body) }
+
+	req, _ := http.NewRequest("GET", server.URL+"/volume", nil)
+	_, err := client.Do(req)
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Message != "invalid volume" {
+		t.Fatalf("unexpected error %v", apiErr)
+	}
+	if attempts != 1 {
+		t.Fatalf("client errors must not be retried, got %d attempts", attempts)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if delay := parseRetryAfter("2"); delay != 2*time.Second {
+		t.Fatalf("expected 2s, got %s", delay)
+	}
+	if delay := parseRetryAfter(""); delay != 0 {
+		t.Fatalf("expected no delay, got %s", delay)
+	}
+}
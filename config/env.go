@@ -1,45 +1,149 @@
 package config
 
 import (
-	"log"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	MQTTServer         string
-	EncryptionKey      string
-	SonosClientId      string
-	SonosClientSecret  string
-	SonosHouseholdId   string
-	HueAuthToken       string
-	EncryptionFilePath string
-	HueBridgeIP        string
-	HueClientId        string
-	HueClientSecret    string
+	MQTTServer               string
+	MQTTUsername             string
+	MQTTPassword             string
+	MQTTClientId             string
+	MQTTCleanSession         bool
+	MQTTCACertFile           string
+	MQTTClientCertFile       string
+	MQTTClientKeyFile        string
+	MQTTTopicPrefix          string
+	MQTTControlQoS           byte
+	MQTTSetupQoS             byte
+	MQTTMaxReconnectInterval time.Duration
+	MQTTStatusTopic          string
+	MQTTLivenessTimeout      time.Duration
+	AvailabilityInterval     time.Duration
+	EncryptionKey            string
+	NewEncryptionKey         string
+	SonosClientId            string
+	SonosClientSecret        string
+	SonosHouseholdId         string
+	SonosPreferredGroup      string
+	SonosGroupPresetsFile    string
+	SonosSleepFade           time.Duration
+	SonosNowPlayingInterval  time.Duration
+	AlbumArtCacheDir         string
+	AlbumArtCacheMaxMB       int
+	HueAuthToken             string
+	EncryptionFilePath       string
+	CredentialStore          string
+	CredentialDBPath         string
+	HistoryDBPath            string
+	HistoryRetention         time.Duration
+	SnapshotFilePath         string
+	SonosAccount             string
+	HueAccount               string
+	HueBridgeIP              string
+	HueMode                  string
+	HueClientId              string
+	HueClientSecret          string
+	HueLightUpdatesPerSecond int
+	HueGroupUpdatesPerSecond int
+	ShutdownTimeout          time.Duration
+	DispatchWorkers          int
+	DispatchQueueDepth       int
+	CommandTimeout           time.Duration
+	CoalesceWindow           time.Duration
+	CoalesceActions          []string
+	DebounceInterval         time.Duration
+	DebounceActions          []string
+	VendorRequestTimeout     time.Duration
+	VendorMaxRetries         int
+	VendorRetryBaseDelay     time.Duration
+	VendorRetryMaxDelay      time.Duration
+	PublicURL                string
+	AdminUsername            string
+	AdminPassword            string
+	LogLevel                 string
+	LogFormat                string
 }
 
 var config Config
 
+// LegacyEncryptionKey is the former default of ENCRYPTION_KEY. It is only
+// tried when reading credentials in the legacy format, so installs that
+// never set a key can migrate.
+const LegacyEncryptionKey = "example key 1234"
+
 func init() {
 	if err := godotenv.Load(); err != nil {
-		log.Print("No .env file found")
+		slog.Info("No .env file found")
 	}
 }
 
 func LoadEnvs() {
 	config = Config{
-		MQTTServer:         getEnvVarOrDefault("MQTT_SERVER", "tcp://localhost:1883"),
-		EncryptionKey:      getEnvVarOrDefault("ENCRYPTION_KEY", "example key 1234"),
-		SonosClientId:      getEnvVarOrDefault("SONOS_CLIENT_ID", ""),
-		SonosClientSecret:  getEnvVarOrDefault("SONOS_CLIENT_SECRET", ""),
-		HueClientId:        getEnvVarOrDefault("HUE_CLIENT_ID", ""),
-		HueClientSecret:    getEnvVarOrDefault("HUE_CLIENT_SECRET", ""),
-		SonosHouseholdId:   getEnvVarOrDefault("SONOS_HOUSEHOLD_ID", ""),
-		HueAuthToken:       getEnvVarOrDefault("HUE_AUTH_TOKEN", ""),
-		EncryptionFilePath: getEnvVarOrDefault("ENCRYPTION_FILE_PATH", "tokens"),
-		HueBridgeIP:        getEnvVarOrDefault("HUE_BRIDGE_IP", "192.168.178.34"),
+		MQTTServer:               getEnvVarOrDefault("MQTT_SERVER", "tcp://localhost:1883"),
+		MQTTUsername:             getEnvVarOrDefault("MQTT_USERNAME", ""),
+		MQTTPassword:             getEnvVarOrDefault("MQTT_PASSWORD", ""),
+		MQTTClientId:             getEnvVarOrDefault("MQTT_CLIENT_ID", "cuore"),
+		MQTTCleanSession:         getEnvVarAsBoolOrDefault("MQTT_CLEAN_SESSION", true),
+		MQTTCACertFile:           getEnvVarOrDefault("MQTT_CA_CERT_FILE", ""),
+		MQTTClientCertFile:       getEnvVarOrDefault("MQTT_CLIENT_CERT_FILE", ""),
+		MQTTClientKeyFile:        getEnvVarOrDefault("MQTT_CLIENT_KEY_FILE", ""),
+		MQTTTopicPrefix:          getEnvVarOrDefault("MQTT_TOPIC_PREFIX", ""),
+		MQTTControlQoS:           getEnvVarAsQoSOrDefault("MQTT_CONTROL_QOS", 0),
+		MQTTSetupQoS:             getEnvVarAsQoSOrDefault("MQTT_SETUP_QOS", 0),
+		MQTTMaxReconnectInterval: getEnvVarAsDurationOrDefault("MQTT_MAX_RECONNECT_INTERVAL", 2*time.Minute),
+		MQTTStatusTopic:          getEnvVarOrDefault("MQTT_STATUS_TOPIC", "cuore/status"),
+		MQTTLivenessTimeout:      getEnvVarAsDurationOrDefault("MQTT_LIVENESS_TIMEOUT", 5*time.Minute),
+		AvailabilityInterval:     getEnvVarAsDurationOrDefault("AVAILABILITY_INTERVAL", time.Minute),
+		EncryptionKey:            getEnvVarOrDefault("ENCRYPTION_KEY", ""),
+		NewEncryptionKey:         getEnvVarOrDefault("NEW_ENCRYPTION_KEY", ""),
+		SonosClientId:            getEnvVarOrDefault("SONOS_CLIENT_ID", ""),
+		SonosClientSecret:        getEnvVarOrDefault("SONOS_CLIENT_SECRET", ""),
+		HueClientId:              getEnvVarOrDefault("HUE_CLIENT_ID", ""),
+		HueClientSecret:          getEnvVarOrDefault("HUE_CLIENT_SECRET", ""),
+		SonosHouseholdId:         getEnvVarOrDefault("SONOS_HOUSEHOLD_ID", ""),
+		SonosPreferredGroup:      getEnvVarOrDefault("SONOS_PREFERRED_GROUP", ""),
+		SonosGroupPresetsFile:    getEnvVarOrDefault("SONOS_GROUP_PRESETS_FILE", ""),
+		SonosSleepFade:           getEnvVarAsDurationOrDefault("SONOS_SLEEP_FADE", 30*time.Second),
+		SonosNowPlayingInterval:  getEnvVarAsDurationOrDefault("SONOS_NOW_PLAYING_INTERVAL", 10*time.Second),
+		AlbumArtCacheDir:         getEnvVarOrDefault("ALBUM_ART_CACHE_DIR", "cache/album-art"),
+		AlbumArtCacheMaxMB:       getEnvVarAsPositiveIntOrDefault("ALBUM_ART_CACHE_MAX_MB", 100),
+		HueAuthToken:             getEnvVarOrDefault("HUE_AUTH_TOKEN", ""),
+		EncryptionFilePath:       getEnvVarOrDefault("ENCRYPTION_FILE_PATH", "tokens"),
+		CredentialStore:          getEnvVarOrDefault("CREDENTIAL_STORE", "file"),
+		CredentialDBPath:         getEnvVarOrDefault("CREDENTIAL_DB_PATH", "credentials/credentials.db"),
+		HistoryDBPath:            getEnvVarOrDefault("HISTORY_DB_PATH", "history/history.db"),
+		HistoryRetention:         getEnvVarAsDurationOrDefault("HISTORY_RETENTION", 30*24*time.Hour),
+		SnapshotFilePath:         getEnvVarOrDefault("SNAPSHOT_FILE_PATH", ""),
+		SonosAccount:             getEnvVarOrDefault("SONOS_ACCOUNT", "default"),
+		HueAccount:               getEnvVarOrDefault("HUE_ACCOUNT", "default"),
+		HueBridgeIP:              getEnvVarOrDefault("HUE_BRIDGE_IP", "192.168.178.34"),
+		HueMode:                  getEnvVarOrDefault("HUE_MODE", "auto"),
+		HueLightUpdatesPerSecond: getEnvVarAsIntOrDefault("HUE_LIGHT_UPDATES_PER_SECOND", 10),
+		HueGroupUpdatesPerSecond: getEnvVarAsIntOrDefault("HUE_GROUP_UPDATES_PER_SECOND", 1),
+		ShutdownTimeout:          getEnvVarAsDurationOrDefault("SHUTDOWN_TIMEOUT", 10*time.Second),
+		DispatchWorkers:          getEnvVarAsPositiveIntOrDefault("DISPATCH_WORKERS", 8),
+		DispatchQueueDepth:       getEnvVarAsPositiveIntOrDefault("DISPATCH_QUEUE_DEPTH", 16),
+		CommandTimeout:           getEnvVarAsDurationOrDefault("COMMAND_TIMEOUT", 15*time.Second),
+		CoalesceWindow:           getEnvVarAsDurationOrDefault("COALESCE_WINDOW", 0),
+		CoalesceActions:          getEnvVarAsListOrDefault("COALESCE_ACTIONS", []string{"volume", "brightness"}),
+		DebounceInterval:         getEnvVarAsDurationOrDefault("DEBOUNCE_INTERVAL", 0),
+		DebounceActions:          getEnvVarAsListOrDefault("DEBOUNCE_ACTIONS", []string{"play", "pause"}),
+		VendorRequestTimeout:     getEnvVarAsDurationOrDefault("VENDOR_REQUEST_TIMEOUT", 5*time.Second),
+		VendorMaxRetries:         getEnvVarAsIntOrDefault("VENDOR_MAX_RETRIES", 3),
+		VendorRetryBaseDelay:     getEnvVarAsDurationOrDefault("VENDOR_RETRY_BASE_DELAY", 250*time.Millisecond),
+		VendorRetryMaxDelay:      getEnvVarAsDurationOrDefault("VENDOR_RETRY_MAX_DELAY", 5*time.Second),
+		PublicURL:                getEnvVarOrDefault("PUBLIC_URL", "http://localhost"),
+		AdminUsername:            getEnvVarOrDefault("ADMIN_USERNAME", "admin"),
+		AdminPassword:            getEnvVarOrDefault("ADMIN_PASSWORD", ""),
+		LogLevel:                 getEnvVarOrDefault("LOG_LEVEL", "info"),
+		LogFormat:                getEnvVarOrDefault("LOG_FORMAT", "text"),
 	}
 }
 
@@ -51,6 +155,85 @@ func getEnvVarOrDefault(envVar string, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvVarAsBoolOrDefault(envVar string, defaultValue bool) bool {
+	value, exists := os.LookupEnv(envVar)
+	if !exists {
+		return defaultValue
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Invalid boolean, using default", "variable", envVar, "error", err, "default", defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+func getEnvVarAsIntOrDefault(envVar string, defaultValue int) int {
+	value, exists := os.LookupEnv(envVar)
+	if !exists {
+		return defaultValue
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("Invalid integer, using default", "variable", envVar, "error", err, "default", defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
+
+// getEnvVarAsPositiveIntOrDefault parses an integer of at least 1, such as a
+// pool or queue size where 0 would stall or reject everything.
+func getEnvVarAsPositiveIntOrDefault(envVar string, defaultValue int) int {
+	value := getEnvVarAsIntOrDefault(envVar, defaultValue)
+	if value < 1 {
+		slog.Warn("Value must be at least 1, using default", "variable", envVar, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvVarAsQoSOrDefault(envVar string, defaultValue byte) byte {
+	qos := getEnvVarAsIntOrDefault(envVar, int(defaultValue))
+	if qos < 0 || qos > 2 {
+		slog.Warn("Invalid QoS, using default", "variable", envVar, "qos", qos, "default", defaultValue)
+		return defaultValue
+	}
+	return byte(qos)
+}
+
+func getEnvVarAsDurationOrDefault(envVar string, defaultValue time.Duration) time.Duration {
+	value, exists := os.LookupEnv(envVar)
+	if !exists {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("Invalid duration, using default", "variable", envVar, "error", err, "default", defaultValue)
+		return defaultValue
+	}
+	return duration
+}
+
+// getEnvVarAsListOrDefault parses a comma separated list, ignoring empty
+// entries.
+func getEnvVarAsListOrDefault(envVar string, defaultValue []string) []string {
+	value, exists := os.LookupEnv(envVar)
+	if !exists {
+		return defaultValue
+	}
+
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
 func Get() *Config {
 	return &config
 }
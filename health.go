@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"cuore/common"
+	"cuore/config"
+	"cuore/integrations"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	availabilityTimeout = 10 * time.Second
+	// readiness probes run far more often than vendor APIs should be polled
+	availabilityCacheTTL = 30 * time.Second
+)
+
+type componentHealth struct {
+	Status     string           `json:"status"`
+	Auth       common.AuthState `json:"auth,omitempty"`
+	Reachable  *bool            `json:"reachable,omitempty"`
+	Discovered *bool            `json:"discovered,omitempty"`
+	Error      string           `json:"error,omitempty"`
+}
+
+const (
+	statusUp   = "up"
+	statusDown = "down"
+	// integrations that are not set up do not affect readiness
+	statusNotConfigured = "not configured"
+)
+
+// mqttHealth tracks the broker connection for the health checks.
+var mqttHealth = struct {
+	mutex     sync.Mutex
+	connected bool
+	since     time.Time
+}{since: time.Now()}
+
+func setMQTTConnected(connected bool) {
+	mqttHealth.mutex.Lock()
+	defer mqttHealth.mutex.Unlock()
+
+	if connected != mqttHealth.connected {
+		mqttHealth.connected = connected
+		mqttHealth.since = time.Now()
+	}
+}
+
+func mqttConnection() (bool, time.Time) {
+	mqttHealth.mutex.Lock()
+	defer mqttHealth.mutex.Unlock()
+
+	return mqttHealth.connected, mqttHealth.since
+}
+
+// availabilityCheck caches the availability of one target. Its mutex is held
+// during the check, so a slow vendor API only delays checks of its own target.
+type availabilityCheck struct {
+	mutex     sync.Mutex
+	err       error
+	checkedAt time.Time
+}
+
+var (
+	availability      = map[string]*availabilityCheck{}
+	availabilityMutex sync.Mutex
+)
+
+// checkAvailable calls Available on the integration unless it has been
+// checked within the cache TTL.
+func checkAvailable(ctx context.Context, target string, integration integrations.Integration) error {
+	availabilityMutex.Lock()
+	check, ok := availability[target]
+	if !ok {
+		check = &availabilityCheck{}
+		availability[target] = check
+	}
+	availabilityMutex.Unlock()
+
+	check.mutex.Lock()
+	defer check.mutex.Unlock()
+
+	if !check.checkedAt.IsZero() && time.Since(check.checkedAt) < availabilityCacheTTL {
+		return check.err
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, availabilityTimeout)
+	defer cancel()
+
+	err := integration.Available(checkCtx)
+	if ctx.Err() == nil {
+		check.err, check.checkedAt = err, time.Now()
+	}
+	return err
+}
+
+// healthHandler is the liveness check. It only fails when the broker has
+// been unreachable for longer than MQTT_LIVENESS_TIMEOUT, as a restart may
+// then be the only way out.
+func healthHandler(c *gin.Context) {
+	connected, since := mqttConnection()
+	timeout := config.Get().MQTTLivenessTimeout
+
+	if !connected && timeout > 0 && time.Since(since) > timeout {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": statusDown,
+			"error":  "not connected to the MQTT broker since " + since.Format(time.RFC3339),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": statusUp})
+}
+
+// readyHandler is the readiness check with a breakdown per component. cuore
+// is ready when it is connected to the broker and every configured
+// integration is authorized, reachable and has discovered its rooms.
+func readyHandler(c *gin.Context) {
+	ready := true
+	components := map[string]componentHealth{}
+
+	connected, _ := mqttConnection()
+	mqttComponent := componentHealth{Status: statusUp}
+	if !connected {
+		ready = false
+		mqttComponent = componentHealth{Status: statusDown, Error: "not connected to the MQTT broker"}
+	}
+	components["mqtt"] = mqttComponent
+
+	for target, integration := range targets {
+		if !integration.Configured() {
+			components[target] = componentHealth{Status: statusNotConfigured}
+			continue
+		}
+
+		component := integrationHealth(c.Request.Context(), target, integration)
+		if component.Status != statusUp {
+			ready = false
+		}
+		components[target] = component
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+
+	c.JSON(code, gin.H{"status": status, "components": components})
+}
+
+func integrationHealth(ctx context.Context, target string, integration integrations.Integration) componentHealth {
+	// checked first, as it loads the token the auth state depends on
+	availableErr := checkAvailable(ctx, target, integration)
+	authState, authErr := integration.AuthState(ctx)
+	reachable := availableErr == nil
+	discovered := integration.Discovered()
+
+	component := componentHealth{
+		Status:     statusUp,
+		Auth:       authState,
+		Reachable:  &reachable,
+		Discovered: &discovered,
+	}
+
+	switch {
+	case authState != common.AuthStateAuthorized && authErr != nil:
+		component.Error = authErr.Error()
+	case !reachable:
+		component.Error = availableErr.Error()
+	}
+
+	if authState != common.AuthStateAuthorized || !reachable || !discovered {
+		component.Status = statusDown
+	}
+
+	return component
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"cuore/common"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// history is nil when the history store could not be opened.
+var history *common.HistoryStore
+
+func controlRecord(source string, msg common.ControlMessage) common.HistoryRecord {
+	record := common.HistoryRecord{
+		Source: source,
+		Kind:   common.HistoryKindControl,
+		Target: msg.Target,
+		Room:   msg.Room,
+		Action: msg.Action,
+	}
+	if msg.Value != nil {
+		record.Value = strconv.Itoa(*msg.Value)
+	}
+	return record
+}
+
+func setupRecord(source string, msg common.SetupMessage) common.HistoryRecord {
+	return common.HistoryRecord{
+		Source: source,
+		Kind:   common.HistoryKindSetup,
+		Target: msg.Target,
+		Action: msg.Command,
+		Value:  msg.Value,
+	}
+}
+
+// recordHistory completes record with the outcome of a message received at
+// received and stores it.
+func recordHistory(ctx context.Context, record common.HistoryRecord, received time.Time, outcome string, err error) {
+	if history == nil {
+		return
+	}
+
+	record.Timestamp = received
+	record.Outcome = outcome
+	record.LatencyMs = time.Since(received).Milliseconds()
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	if err := history.Record(record); err != nil {
+		slog.ErrorContext(ctx, "Error recording history", "error", err)
+	}
+}
+
+// historyHandler returns recorded messages, newest first. It accepts the
+// filters target, room and action, a time range from/to in RFC 3339 and a
+// limit.
+func historyHandler(c *gin.Context) {
+	if history == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"code": http.StatusServiceUnavailable, "message": "History is not available"})
+		return
+	}
+
+	filter := common.HistoryFilter{
+		Target: c.Query("target"),
+		Room:   c.Query("room"),
+		Action: c.Query("action"),
+	}
+
+	var err error
+	if filter.From, err = parseTimeQuery(c, "from"); err == nil {
+		filter.To, err = parseTimeQuery(c, "to")
+	}
+	if err == nil && c.Query("limit") != "" {
+		filter.Limit, err = strconv.Atoi(c.Query("limit"))
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": http.StatusBadRequest, "message": err.Error()})
+		return
+	}
+
+	records, err := history.Query(filter)
+	if err != nil {
+		slog.ErrorContext(c, "Error querying history", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"code": http.StatusInternalServerError, "message": "Failed to query history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, records)
+}
+
+func parseTimeQuery(c *gin.Context, name string) (time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s, expected RFC 3339: %w", name, err)
+	}
+	return parsed, nil
+}
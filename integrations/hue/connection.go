@@ -0,0 +1,171 @@
+package hue
+
+import (
+	"bytes"
+	"context"
+	"cuore/common"
+	"cuore/config"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var remoteBaseURL = "https://api.meethue.com/route/api"
+
+const (
+	bridgeCheckInterval = 30 * time.Second
+	bridgeCheckTimeout  = 2 * time.Second
+)
+
+// client sends all requests to the bridge and the Remote API.
+var client = newClient()
+
+func newClient() *common.VendorClient {
+	client := common.NewVendorClient("Hue")
+	client.ErrorMessage = errorMessage
+	client.CheckBody = checkBody
+	return client
+}
+
+// errorResponse is the list the bridge answers with when a request fails,
+// usually with status 200.
+type errorResponse []struct {
+	Error *struct {
+		Type        int    `json:"type"`
+		Address     string `json:"address"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// remoteFault is the error body of the Remote API gateway.
+type remoteFault struct {
+	Fault *struct {
+		FaultString string `json:"faultstring"`
+	} `json:"fault"`
+}
+
+// bridgeErrors returns the descriptions of all errors in body.
+func bridgeErrors(body []byte) []string {
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		return nil
+	}
+
+	var response errorResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil
+	}
+
+	var descriptions []string
+	for _, entry := range response {
+		if entry.Error != nil {
+			descriptions = append(descriptions, fmt.Sprintf("%s (type %d at %s)", entry.Error.Description, entry.Error.Type, entry.Error.Address))
+		}
+	}
+	return descriptions
+}
+
+func errorMessage(body []byte) string {
+	if descriptions := bridgeErrors(body); len(descriptions) > 0 {
+		return strings.Join(descriptions, ", ")
+	}
+
+	var fault remoteFault
+	if err := json.Unmarshal(body, &fault); err == nil && fault.Fault != nil {
+		return fault.Fault.FaultString
+	}
+
+	return strings.TrimSpace(string(body))
+}
+
+// checkBody turns errors the bridge reports with a successful status into
+// an APIError.
+func checkBody(res *http.Response, body []byte) error {
+	descriptions := bridgeErrors(body)
+	if len(descriptions) == 0 {
+		return nil
+	}
+
+	return &common.APIError{
+		Vendor:     "Hue",
+		Method:     res.Request.Method,
+		Path:       res.Request.URL.Path,
+		StatusCode: res.StatusCode,
+		Message:    strings.Join(descriptions, ", "),
+	}
+}
+
+// useRemote decides whether requests go through the Hue Remote API. In auto
+// mode the bridge is used directly whenever it is reachable.
+func (h *Hue) useRemote(ctx context.Context) bool {
+	switch config.Get().HueMode {
+	case "local":
+		return false
+	case "remote":
+		return true
+	default:
+		return !h.bridgeReachable(ctx)
+	}
+}
+
+// bridgeReachable checks whether the bridge answers on the local network.
+// The result is cached, so not every command waits for the check.
+func (h *Hue) bridgeReachable(ctx context.Context) bool {
+	h.bridgeMutex.Lock()
+	defer h.bridgeMutex.Unlock()
+
+	if time.Since(h.bridgeCheckedAt) < bridgeCheckInterval {
+		return h.bridgeOnline
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, bridgeCheckTimeout)
+	defer cancel()
+
+	online := false
+	url := fmt.Sprintf("http://%s/api/config", config.Get().HueBridgeIP)
+	if req, err := http.NewRequestWithContext(checkCtx, "GET", url, nil); err == nil {
+		if res, err := http.DefaultClient.Do(req); err == nil {
+			res.Body.Close()
+			online = res.StatusCode == 200
+		}
+	}
+
+	if online != h.bridgeOnline || h.bridgeCheckedAt.IsZero() {
+		if online {
+			slog.InfoContext(ctx, "Hue bridge is reachable, using the local API")
+		} else {
+			slog.WarnContext(ctx, "Hue bridge is not reachable, using the Remote API")
+		}
+	}
+
+	h.bridgeOnline = online
+	h.bridgeCheckedAt = time.Now()
+	return online
+}
+
+// apiURL returns the base URL for requests with the application key, either
+// on the bridge or routed through the Remote API.
+func (h *Hue) apiURL(remote bool) string {
+	if remote {
+		return remoteBaseURL
+	}
+	return fmt.Sprintf("http://%s/api", config.Get().HueBridgeIP)
+}
+
+// authorize adds the credentials for the chosen API to the request. The Remote
+// API additionally needs the OAuth token of the account.
+func authorize(req *http.Request, remote bool) error {
+	if !remote {
+		req.Header.Add("Authorization", authenticationToken())
+		return nil
+	}
+
+	token, err := tokens.Token(req.Context())
+	if err != nil {
+		return fmt.Errorf("no valid Hue token for the Remote API: %w", err)
+	}
+	token.SetAuthHeader(req)
+	return nil
+}
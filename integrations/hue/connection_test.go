@@ -0,0 +1,79 @@
+package hue
+
+import (
+	"context"
+	"cuore/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestUseRemoteFallsBackWhenBridgeIsUnreachable(t *testing.T) {
+	bridge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer bridge.Close()
+
+	defer func(mode string, ip string) { config.Get().HueMode, config.Get().HueBridgeIP = mode, ip }(config.Get().HueMode, config.Get().HueBridgeIP)
+	config.Get().HueBridgeIP = strings.TrimPrefix(bridge.URL, "http://")
+	ctx := context.Background()
+
+	config.Get().HueMode = "local"
+	if (&Hue{}).useRemote(ctx) {
+		t.Fatal("expected local mode to use the bridge")
+	}
+	config.Get().HueMode = "remote"
+	if !(&Hue{}).useRemote(ctx) {
+		t.Fatal("expected remote mode to use the Remote API")
+	}
+
+	config.Get().HueMode = "auto"
+	h := &Hue{}
+	if h.useRemote(ctx) {
+		t.Fatal("expected the reachable bridge to be used")
+	}
+
+	bridge.Close()
+	if h.useRemote(ctx) {
+		t.Fatal("expected the result of the bridge check to be cached")
+	}
+	if !(&Hue{}).useRemote(ctx) {
+		t.Fatal("expected the Remote API when the bridge is unreachable")
+	}
+}
+
+func TestRemoteRequestsUseTheRouteAPI(t *testing.T) {
+	config.Get().CredentialStore = "file"
+	config.Get().EncryptionFilePath = t.TempDir()
+	config.Get().EncryptionKey = "example key 1234"
+	config.Get().VendorRequestTimeout = time.Second
+
+	defer func(mode string, key string) { config.Get().HueMode, config.Get().HueAuthToken = mode, key }(config.Get().HueMode, config.Get().HueAuthToken)
+	config.Get().HueMode = "remote"
+	config.Get().HueAuthToken = "application-key"
+
+	var path, authorization string
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, authorization = r.URL.Path, r.Header.Get("Authorization")
+		w.Write([]byte("{}"))
+	}))
+	defer remote.Close()
+
+	defer func(url string) { remoteBaseURL = url }(remoteBaseURL)
+	remoteBaseURL = remote.URL + "/route/api"
+	if err := tokens.SetToken(&oauth2.Token{AccessToken: "access", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (&Hue{}).hueAPIRequest(context.Background(), "groups", "GET", nil); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/route/api/application-key/groups" {
+		t.Fatalf("expected the request to be routed with the application key, got %s", path)
+	}
+	if authorization != "Bearer access" {
+		t.Fatalf("expected the OAuth token of the account, got %q", authorization)
+	}
+}
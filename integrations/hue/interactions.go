@@ -1,62 +1,69 @@
 package hue
 
 import (
+	"context"
 	"cuore/common"
 	"cuore/config"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"strings"
+	"sync"
 )
 
 var (
-	groups = map[string]string{} // room -> groupId
+	groups      = map[string]string{}   // room -> groupId
+	groupLights = map[string][]string{} // groupId -> lightIds
+	groupsMutex sync.RWMutex
 )
 
-func (h *Hue) HandleControl(msg common.ControlMessage) error {
-	if err := h.updateGroups(); err != nil {
+// controlActions are the actions handled by HandleControl.
+var controlActions = []string{"on", "off", "brightness", "snapshot", "restore"}
+
+func (h *Hue) Actions() []string {
+	return controlActions
+}
+
+func (h *Hue) HandleControl(ctx context.Context, msg common.ControlMessage) error {
+	if err := h.updateGroups(ctx); err != nil {
 		return fmt.Errorf("failed to update groups: %w", err)
 	}
 
 	switch msg.Action {
 	case "on":
-		return h.setGroupStatus(msg.Room, true)
+		return h.setGroupStatus(ctx, msg.Room, true)
 	case "off":
-		return h.setGroupStatus(msg.Room, false)
+		return h.setGroupStatus(ctx, msg.Room, false)
 	case "brightness":
 		if msg.Value == nil {
 			return fmt.Errorf("brightness action requires a value")
 		}
-		return h.setGroupBrightness(msg.Room, *msg.Value)
+		return h.setGroupBrightness(ctx, msg.Room, *msg.Value)
+	case "snapshot":
+		return h.Snapshot(ctx, msg.Room, msg.Name)
+	case "restore":
+		return h.Restore(ctx, msg.Room, msg.Name)
 	default:
 		return fmt.Errorf("unknown action: %s", msg.Action)
 	}
 }
 
-func (h *Hue) HandleSetup(msg common.SetupMessage) error {
+func (h *Hue) HandleSetup(ctx context.Context, msg common.SetupMessage) error {
 	switch msg.Command {
 	case "discover":
 		return h.Autodiscover()
 	case "authorize":
-		// TODO: Implement authorization
-		return fmt.Errorf("authorization not implemented")
+		return h.createApplicationKey(ctx)
 	default:
 		return fmt.Errorf("unknown setup command: %s", msg.Command)
 	}
 }
 
-func (h *Hue) updateGroups() error {
-	res, err := hueAPIRequest("groups", "GET", nil)
+func (h *Hue) updateGroups(ctx context.Context) error {
+	body, err := h.hueAPIRequest(ctx, "groups", "GET", nil)
 	if err != nil {
-		return fmt.Errorf("failed to make request to Hue API: %w", err)
-	}
-	defer res.Body.Close()
-
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return err
 	}
 
 	var groupsResponse map[string]GroupResponse
@@ -64,81 +71,98 @@ func (h *Hue) updateGroups() error {
 		return fmt.Errorf("error decoding JSON: %w", err)
 	}
 
+	common.RecordStateSync(providerName)
+	h.discovered.Store(true)
+
+	groupsMutex.Lock()
+	defer groupsMutex.Unlock()
+
 	for id, group := range groupsResponse {
 		groups[group.Name] = id
+		groupLights[id] = group.Lights
 	}
 
 	return nil
 }
 
-func hueAPIRequest(url string, method string, payload io.Reader) (*http.Response, error) {
-	fullUrl := fmt.Sprintf("http://%s/api/%s/%s", config.Get().HueBridgeIP, authenticationToken(), url)
-	req, err := http.NewRequest(method, fullUrl, payload)
+func groupId(room string) (string, bool) {
+	groupsMutex.RLock()
+	defer groupsMutex.RUnlock()
+
+	id, ok := groups[room]
+	return id, ok
+}
+
+// lightsOfGroup returns a copy of the light ids of a group.
+func lightsOfGroup(groupId string) []string {
+	groupsMutex.RLock()
+	defer groupsMutex.RUnlock()
+
+	return append([]string{}, groupLights[groupId]...)
+}
+
+// hueAPIRequest sends a request with the application key and returns the
+// body of the response.
+func (h *Hue) hueAPIRequest(ctx context.Context, url string, method string, payload io.Reader) ([]byte, error) {
+	remote := h.useRemote(ctx)
+	fullUrl := fmt.Sprintf("%s/%s/%s", h.apiURL(remote), authenticationToken(), url)
+	req, err := http.NewRequestWithContext(ctx, method, fullUrl, payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Add("accept", "application/json")
 	req.Header.Add("content-type", "application/json")
-	req.Header.Add("Authorization", authenticationToken())
+	if err := authorize(req, remote); err != nil {
+		return nil, err
+	}
 
-	return http.DefaultClient.Do(req)
+	return client.Do(req)
 }
 
+// authenticationToken returns the application key (the whitelisted bridge
+// username), falling back to HUE_AUTH_TOKEN if none has been stored.
 func authenticationToken() string {
-	// token, _ := getToken()
-	// log.Print("Hue Token: ", token.AccessToken)
-	return config.Get().HueAuthToken
+	applicationKey, err := common.GetSecret(providerName, config.Get().HueAccount, applicationKeySecret)
+	if err != nil {
+		applicationKey = config.Get().HueAuthToken
+	}
+
+	// the key is part of every request path
+	common.RegisterSecret(applicationKey)
+	return applicationKey
 }
 
 func (h *Hue) Autodiscover() error {
 	// TODO: Implement autodiscovery
-	log.Print("Autodiscovery not implemented")
+	slog.Warn("Hue autodiscovery not implemented")
 	return nil
 }
 
-func (h *Hue) setGroupStatus(room string, state bool) error {
-	groupId, ok := groups[room]
+func (h *Hue) setGroupStatus(ctx context.Context, room string, state bool) error {
+	groupId, ok := groupId(room)
 	if !ok {
 		return fmt.Errorf("room %s not found", room)
 	}
 
-	url := fmt.Sprintf("groups/%s/action", groupId)
-	payload := strings.NewReader(fmt.Sprintf("{\"on\": %t}", state))
-
-	res, err := hueAPIRequest(url, "PUT", payload)
-	if err != nil {
-		return fmt.Errorf("failed to make request to Hue API: %w", err)
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != 200 {
-		body, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("failed to set group status: %s", string(body))
+	update := lightState{On: &state}
+	if err := h.scheduler.update(ctx, groupId, lightsOfGroup(groupId), update, h.sendState); err != nil {
+		return fmt.Errorf("failed to set group status: %w", err)
 	}
 
 	return nil
 }
 
-func (h *Hue) setGroupBrightness(room string, value int) error {
-	groupId, ok := groups[room]
+func (h *Hue) setGroupBrightness(ctx context.Context, room string, value int) error {
+	groupId, ok := groupId(room)
 	if !ok {
 		return fmt.Errorf("room %s not found", room)
 	}
 
-	url := fmt.Sprintf("groups/%s/action", groupId)
 	hueValue := int((float64(value) / 100) * 254)
-	payload := strings.NewReader(fmt.Sprintf("{\"bri\": %v}", hueValue))
-
-	res, err := hueAPIRequest(url, "PUT", payload)
-	if err != nil {
-		return fmt.Errorf("failed to make request to Hue API: %w", err)
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != 200 {
-		body, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("failed to set group brightness: %s", string(body))
+	update := lightState{Brightness: &hueValue}
+	if err := h.scheduler.update(ctx, groupId, lightsOfGroup(groupId), update, h.sendState); err != nil {
+		return fmt.Errorf("failed to set group brightness: %w", err)
 	}
 
 	return nil
@@ -1,45 +1,61 @@
 package hue
 
 import (
+	"cuore/common"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/oauth2"
 )
 
-func (h Hue) AuthorizationHandlers(hueRoutes *gin.RouterGroup) {
+func (h *Hue) AuthorizationHandlers(hueRoutes *gin.RouterGroup) {
 	hueRoutes.GET("/", func(c *gin.Context) {
-		authURL := getAuthConfig().AuthCodeURL("state", oauth2.AccessTypeOffline) //should be random code
+		state, opts, err := common.NewAuthState(providerName, usePKCE)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		opts = append(opts, oauth2.AccessTypeOffline)
+		authURL := getAuthConfig().AuthCodeURL(state, opts...)
 		c.Redirect(http.StatusFound, authURL)
 	})
 
 	hueRoutes.GET("/auth", func(c *gin.Context) {
-		code := c.Query("code")
-		newToken, err := getAuthConfig().Exchange(c, code)
+		if authErr := c.Query("error"); authErr != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": authErr})
+			return
+		}
 
+		opts, err := common.ConsumeAuthState(providerName, c.Query("state"))
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		setToken(newToken)
+		code := c.Query("code")
+		newToken, err := getAuthConfig().Exchange(c, code, opts...)
 
-		c.JSON(http.StatusOK, gin.H{"token": newToken})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := tokens.SetToken(newToken); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 
+		c.JSON(http.StatusOK, gin.H{"token": common.RedactToken(newToken)})
 	})
 
 	hueRoutes.GET("/refresh", func(c *gin.Context) {
-		token := &oauth2.Token{
-			RefreshToken: c.Query("refresh_token"),
-		}
-		newToken, err := getAuthConfig().TokenSource(c, token).Token()
+		newToken, err := tokens.Refresh(c)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		setToken(newToken)
-
-		c.JSON(http.StatusOK, gin.H{"token": newToken})
+		c.JSON(http.StatusOK, gin.H{"token": common.RedactToken(newToken)})
 	})
 }
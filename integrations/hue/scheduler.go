@@ -0,0 +1,220 @@
+package hue
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// lightState is the body of a light or group update. Unset fields are left
+// unchanged by the bridge.
+type lightState struct {
+	On               *bool       `json:"on,omitempty"`
+	Brightness       *int        `json:"bri,omitempty"`
+	Hue              *int        `json:"hue,omitempty"`
+	Saturation       *int        `json:"sat,omitempty"`
+	XY               *[2]float64 `json:"xy,omitempty"`
+	ColorTemperature *int        `json:"ct,omitempty"`
+}
+
+// merge returns s with the fields set in newer applied on top.
+func (s lightState) merge(newer lightState) lightState {
+	if newer.On != nil {
+		s.On = newer.On
+	}
+	if newer.Brightness != nil {
+		s.Brightness = newer.Brightness
+	}
+	// a light has one color mode, so a new color replaces all color fields
+	if newer.Hue != nil || newer.Saturation != nil || newer.XY != nil || newer.ColorTemperature != nil {
+		s.Hue, s.Saturation, s.XY, s.ColorTemperature = newer.Hue, newer.Saturation, newer.XY, newer.ColorTemperature
+	}
+	return s
+}
+
+// tokenBucket allows rate updates per second with bursts of up to burst.
+type tokenBucket struct {
+	mutex   sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	updated time.Time
+}
+
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, updated: time.Now()}
+}
+
+// refill must be called with the mutex held.
+func (b *tokenBucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.updated).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.updated = now
+}
+
+// delay returns how long it takes until n tokens are available.
+func (b *tokenBucket) delay(n int) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	return b.delayLocked(n)
+}
+
+func (b *tokenBucket) delayLocked(n int) time.Duration {
+	missing := float64(n) - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(missing / b.rate * float64(time.Second))
+}
+
+// reserve takes n tokens and returns how long to wait before using them.
+func (b *tokenBucket) reserve(n int) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refill()
+	delay := b.delayLocked(n)
+	b.tokens -= float64(n)
+	return delay
+}
+
+// scheduler paces updates to the bridge, which drops commands beyond
+// roughly 10 light updates or 1 group update per second. Updates to a group
+// that is still waiting for its turn are merged into the waiting one.
+type scheduler struct {
+	lights *tokenBucket
+	groups *tokenBucket
+
+	mutex   sync.Mutex
+	pending map[string]*pendingUpdate
+}
+
+type pendingUpdate struct {
+	state lightState
+	done  chan struct{}
+	err   error
+}
+
+// sendFunc sends a state to a light or group resource path of the bridge.
+type sendFunc func(ctx context.Context, path string, state lightState) error
+
+func newScheduler(lightsPerSecond int, groupsPerSecond int) *scheduler {
+	if lightsPerSecond < 1 {
+		lightsPerSecond = 1
+	}
+	if groupsPerSecond < 1 {
+		groupsPerSecond = 1
+	}
+
+	return &scheduler{
+		lights:  newTokenBucket(float64(lightsPerSecond), float64(lightsPerSecond)),
+		groups:  newTokenBucket(float64(groupsPerSecond), float64(groupsPerSecond)),
+		pending: map[string]*pendingUpdate{},
+	}
+}
+
+// update applies state to the group once the budget allows it. When the
+// light budget is available sooner than the group budget, the update is
+// sent to each light of the group instead.
+func (s *scheduler) update(ctx context.Context, groupId string, lights []string, state lightState, send sendFunc) error {
+	s.mutex.Lock()
+	if update, ok := s.pending[groupId]; ok {
+		update.state = update.state.merge(state)
+		s.mutex.Unlock()
+
+		select {
+		case <-update.done:
+			return update.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	update := &pendingUpdate{state: state, done: make(chan struct{})}
+	s.pending[groupId] = update
+	s.mutex.Unlock()
+
+	update.err = s.send(ctx, groupId, lights, update, send)
+	close(update.done)
+	return update.err
+}
+
+// updateLight applies state to a single light once the light budget allows
+// it.
+func (s *scheduler) updateLight(ctx context.Context, lightId string, state lightState, send sendFunc) error {
+	timer := time.NewTimer(s.lights.reserve(1))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+
+	return send(ctx, fmt.Sprintf("lights/%s/state", lightId), state)
+}
+
+func (s *scheduler) send(ctx context.Context, groupId string, lights []string, update *pendingUpdate, send sendFunc) error {
+	perLight := len(lights) > 0 && s.lights.delay(len(lights)) < s.groups.delay(1)
+
+	var delay time.Duration
+	if perLight {
+		delay = s.lights.reserve(len(lights))
+	} else {
+		delay = s.groups.reserve(1)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+
+	// updates merged from here on start a new pending update
+	s.mutex.Lock()
+	delete(s.pending, groupId)
+	state := update.state
+	s.mutex.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !perLight {
+		return send(ctx, fmt.Sprintf("groups/%s/action", groupId), state)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(lights))
+	for i, light := range lights {
+		wg.Add(1)
+		go func(i int, light string) {
+			defer wg.Done()
+			errs[i] = send(ctx, fmt.Sprintf("lights/%s/state", light), state)
+		}(i, light)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// sendState is the sendFunc for the bridge the integration talks to.
+func (h *Hue) sendState(ctx context.Context, path string, state lightState) error {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.hueAPIRequest(ctx, path, "PUT", bytes.NewReader(payload))
+	return err
+}
@@ -0,0 +1,40 @@
+package hue
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestSchedulerSplitsGroupUpdatesWhenGroupBudgetIsUsed(t *testing.T) {
+	s := newScheduler(10, 1)
+
+	var mutex sync.Mutex
+	var paths []string
+	send := func(ctx context.Context, path string, state lightState) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		paths = append(paths, path)
+		return nil
+	}
+
+	on := true
+	for i := 0; i < 2; i++ {
+		if err := s.update(context.Background(), "1", []string{"3", "4"}, lightState{On: &on}, send); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(paths) != 3 || paths[0] != "groups/1/action" {
+		t.Fatalf("expected one group and two light updates, got %v", paths)
+	}
+}
+
+func TestLightStateMerge(t *testing.T) {
+	on := true
+	brightness := 100
+	merged := lightState{On: &on}.merge(lightState{Brightness: &brightness})
+	if merged.On == nil || !*merged.On || merged.Brightness == nil || *merged.Brightness != 100 {
+		t.Fatalf("unexpected merged state %+v", merged)
+	}
+}
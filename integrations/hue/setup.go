@@ -1,8 +1,14 @@
 package hue
 
 import (
+	"context"
 	"cuore/common"
 	"cuore/config"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
 
 	"golang.org/x/oauth2"
 )
@@ -10,19 +16,19 @@ import (
 const (
 	authURL      = "https://api.meethue.com/v2/oauth2/authorize"
 	tokenURL     = "https://api.meethue.com/v2/oauth2/token"
-	redirectURL  = "http://localhost/integrations/hue/auth"
+	redirectPath = "/integrations/hue/auth"
 	providerName = "hue"
-)
 
-var (
-	token *oauth2.Token
+	applicationKeySecret = "application-key"
+	// whether the authorization server verifies a PKCE challenge
+	usePKCE = true
 )
 
 func getAuthConfig() *oauth2.Config {
 	return &oauth2.Config{
 		ClientID:     config.Get().HueClientId,
 		ClientSecret: config.Get().HueClientSecret,
-		RedirectURL:  redirectURL,
+		RedirectURL:  strings.TrimSuffix(config.Get().PublicURL, "/") + redirectPath,
 		Endpoint: oauth2.Endpoint{
 			AuthURL:   authURL,
 			TokenURL:  tokenURL,
@@ -31,26 +37,130 @@ func getAuthConfig() *oauth2.Config {
 	}
 }
 
-func setToken(newToken *oauth2.Token) error {
-	err := common.SaveTokenForProvider(providerName, newToken)
+var tokens = common.NewTokenManager(providerName, func() string { return config.Get().HueAccount }, getAuthConfig)
+
+type createUserResponse []struct {
+	Success *struct {
+		Username string `json:"username"`
+	} `json:"success"`
+}
+
+// createApplicationKey registers cuore with the bridge and stores the
+// returned application key. Locally, the link button on the bridge has to be
+// pressed shortly before; remotely, it is pressed virtually.
+func (h *Hue) createApplicationKey(ctx context.Context) error {
+	remote := h.useRemote(ctx)
+	if remote {
+		if err := h.remotePressLinkButton(ctx); err != nil {
+			return err
+		}
+	}
+
+	payload := strings.NewReader(`{"devicetype": "cuore#server"}`)
+	req, err := http.NewRequestWithContext(ctx, "POST", h.apiURL(remote), payload)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("content-type", "application/json")
+	if remote {
+		if err := authorize(req, remote); err != nil {
+			return err
+		}
+	}
+
+	body, err := client.Do(req)
+	if err != nil {
+		// the bridge reports a link button that has not been pressed as an error
+		return fmt.Errorf("failed to create application key: %w", err)
+	}
+
+	var response createUserResponse
+	if err := json.Unmarshal(body, &response); err != nil || len(response) == 0 {
+		return fmt.Errorf("unexpected response from Hue API: %v", err)
+	}
+	if response[0].Success == nil {
+		return fmt.Errorf("unexpected response from Hue API")
+	}
+
+	slog.InfoContext(ctx, "Created Hue application key")
+	return common.SaveSecret(providerName, config.Get().HueAccount, applicationKeySecret, response[0].Success.Username)
+}
+
+// remotePressLinkButton enables the link button through the Remote API, which
+// allows creating an application key without physical access to the bridge.
+func (h *Hue) remotePressLinkButton(ctx context.Context) error {
+	url := fmt.Sprintf("%s/0/config", remoteBaseURL)
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, strings.NewReader(`{"linkbutton": true}`))
 	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("content-type", "application/json")
+	if err := authorize(req, true); err != nil {
 		return err
 	}
 
-	token = newToken
+	if _, err := client.Do(req); err != nil {
+		return fmt.Errorf("failed to press link button: %w", err)
+	}
+	return nil
+}
+
+func (h *Hue) Start(ctx context.Context) error {
+	h.ctx, h.cancel = context.WithCancel(ctx)
+	h.scheduler = newScheduler(config.Get().HueLightUpdatesPerSecond, config.Get().HueGroupUpdatesPerSecond)
+	go tokens.Run(h.ctx)
+	go common.RetryUntilSuccess(h.ctx, "Hue group discovery", config.Get().CommandTimeout, h.updateGroups)
+	return nil
+}
+
+func (h *Hue) Stop(ctx context.Context) error {
+	if h.cancel != nil {
+		h.cancel()
+	}
 
 	return nil
 }
 
-func getToken() (*oauth2.Token, error) {
-	if token != nil {
-		return token, nil
+// Available reports whether an application key is configured and the bridge
+// accepts it.
+func (h *Hue) Available(ctx context.Context) error {
+	if authenticationToken() == "" {
+		return fmt.Errorf("no Hue application key configured")
 	}
 
-	token, err := common.GetTokenForProvider(providerName)
+	body, err := h.hueAPIRequest(ctx, "groups", "GET", nil)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	var groupsResponse map[string]GroupResponse
+	if err := json.Unmarshal(body, &groupsResponse); err != nil {
+		return fmt.Errorf("unexpected response from Hue API: %w", err)
 	}
 
-	return token, nil
+	return nil
+}
+
+// Configured reports whether an OAuth client for the Remote API or an
+// application key for the bridge is set up.
+func (h *Hue) Configured() bool {
+	return config.Get().HueClientId != "" || authenticationToken() != ""
+}
+
+// Discovered reports whether the groups of the bridge have been loaded once.
+func (h *Hue) Discovered() bool {
+	return h.discovered.Load()
+}
+
+// AuthState reports whether an application key for the bridge is available
+// and, when the Remote API is in use, whether the account is authorized.
+func (h *Hue) AuthState(ctx context.Context) (common.AuthState, error) {
+	if authenticationToken() == "" {
+		return common.AuthStateUnauthorized, fmt.Errorf("no Hue application key configured")
+	}
+
+	if h.useRemote(ctx) {
+		return tokens.State()
+	}
+	return common.AuthStateAuthorized, nil
 }
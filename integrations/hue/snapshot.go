@@ -0,0 +1,95 @@
+package hue
+
+import (
+	"context"
+	"cuore/common"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+)
+
+const snapshotTarget = "light"
+
+// snapshot is the state of every light of a room.
+type snapshot struct {
+	Lights map[string]lightState `json:"lights"`
+}
+
+// Snapshot records on/off, brightness and color of each light in the room.
+func (h *Hue) Snapshot(ctx context.Context, room string, name string) error {
+	groupId, ok := groupId(room)
+	if !ok {
+		return fmt.Errorf("room %s not found", room)
+	}
+
+	snap := snapshot{Lights: map[string]lightState{}}
+	for _, lightId := range lightsOfGroup(groupId) {
+		state, err := h.readLightState(ctx, lightId)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot light %s: %w", lightId, err)
+		}
+		snap.Lights[lightId] = state
+	}
+
+	if err := common.SaveSnapshot(snapshotTarget, room, name, snap); err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "Saved snapshot", "room", room, "snapshot", name)
+	return nil
+}
+
+// Restore puts every light of a snapshot back into its recorded state.
+func (h *Hue) Restore(ctx context.Context, room string, name string) error {
+	var snap snapshot
+	if err := common.LoadSnapshot(snapshotTarget, room, name, &snap); err != nil {
+		return err
+	}
+
+	for lightId, state := range snap.Lights {
+		// the bridge rejects brightness and color changes of lights that are off
+		if state.On != nil && !*state.On {
+			state = lightState{On: state.On}
+		}
+
+		if err := h.scheduler.updateLight(ctx, lightId, state, h.sendState); err != nil {
+			return fmt.Errorf("failed to restore light %s: %w", lightId, err)
+		}
+	}
+
+	slog.InfoContext(ctx, "Restored snapshot", "room", room, "snapshot", name)
+	return nil
+}
+
+// readLightState reads the current state of a light in the form it is sent back.
+func (h *Hue) readLightState(ctx context.Context, lightId string) (lightState, error) {
+	var state lightState
+
+	body, err := h.hueAPIRequest(ctx, "lights/"+lightId, "GET", nil)
+	if err != nil {
+		return state, err
+	}
+
+	var light LightResponse
+	if err := json.Unmarshal(body, &light); err != nil {
+		return state, fmt.Errorf("error decoding JSON: %w", err)
+	}
+
+	on, brightness := light.State.On, light.State.Brightness
+	state.On = &on
+	state.Brightness = &brightness
+
+	switch light.State.ColorMode {
+	case "xy":
+		if len(light.State.XY) == 2 {
+			state.XY = &[2]float64{light.State.XY[0], light.State.XY[1]}
+		}
+	case "ct":
+		state.ColorTemperature = light.State.ColorTemperature
+	case "hs":
+		state.Hue = light.State.Hue
+		state.Saturation = light.State.Saturation
+	}
+
+	return state, nil
+}
@@ -1,12 +1,42 @@
 package hue
 
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
 type GroupResponse struct {
 	Name   string
 	Lights []string
 }
 
+type LightResponse struct {
+	Name  string `json:"name"`
+	State struct {
+		On               bool      `json:"on"`
+		Brightness       int       `json:"bri"`
+		Hue              *int      `json:"hue"`
+		Saturation       *int      `json:"sat"`
+		XY               []float64 `json:"xy"`
+		ColorTemperature *int      `json:"ct"`
+		ColorMode        string    `json:"colormode"`
+	} `json:"state"`
+}
+
 type Hue struct {
 	State State
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	scheduler  *scheduler
+	discovered atomic.Bool
+
+	bridgeMutex     sync.Mutex
+	bridgeOnline    bool
+	bridgeCheckedAt time.Time
 }
 
 type State struct {
@@ -1,8 +1,54 @@
 package integrations
 
-import "cuore/common"
+import (
+	"context"
+	"cuore/common"
+)
 
 type Integration interface {
-	HandleControl(msg common.ControlMessage) error
-	HandleSetup(msg common.SetupMessage) error
+	// Start is called once before any messages are handled. The context is
+	// cancelled when cuore shuts down and should be used for vendor requests.
+	Start(ctx context.Context) error
+	// Stop is called after message handling has ended and should release
+	// everything the integration holds before the context deadline.
+	Stop(ctx context.Context) error
+	// Available reports nil when the integration is authorized and its vendor
+	// API is reachable.
+	Available(ctx context.Context) error
+	// AuthState reports whether the integration holds valid credentials and
+	// the error that caused it to lose them.
+	AuthState(ctx context.Context) (common.AuthState, error)
+	// Configured reports whether the integration has been set up at all.
+	// Integrations that are not are left out of the readiness check.
+	Configured() bool
+	// Discovered reports whether the initial discovery of rooms and groups,
+	// started by Start, has finished.
+	Discovered() bool
+	// HandleControl and HandleSetup are called with a context carrying the
+	// command deadline. Commands for different rooms may run concurrently.
+	HandleControl(ctx context.Context, msg common.ControlMessage) error
+	HandleSetup(ctx context.Context, msg common.SetupMessage) error
+	// Actions lists the control actions HandleControl accepts.
+	Actions() []string
+}
+
+// MultiRoomActions is implemented by integrations with actions that change
+// several rooms at once. Those are queued under "<target>/*" rather than the
+// room of the message.
+type MultiRoomActions interface {
+	MultiRoom(action string) bool
+}
+
+// RoomStateReporter is implemented by integrations that keep state per room.
+type RoomStateReporter interface {
+	// RoomState returns the current state of the room, or false when the
+	// room is unknown.
+	RoomState(room string) (any, bool)
+}
+
+// NowPlayingReporter is implemented by integrations that play media.
+type NowPlayingReporter interface {
+	// NowPlaying returns what is playing in the room, or false when it is
+	// not known.
+	NowPlaying(room string) (any, bool)
 }
@@ -0,0 +1,112 @@
+package sonos
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// Capabilities a player reports in the groups response.
+const (
+	capabilityHomeTheaterPlayback = "HT_PLAYBACK"
+	capabilityLineIn              = "LINE_IN"
+)
+
+// audioSetting is a home-theater switch, set through the given namespace of
+// the Control API.
+type audioSetting struct {
+	capability string
+	field      string
+	path       string // player path the setting is posted to
+}
+
+var audioSettings = map[string]audioSetting{
+	"night-mode":         {capability: capabilityHomeTheaterPlayback, field: "nightMode", path: "homeTheater/options"},
+	"speech-enhancement": {capability: capabilityHomeTheaterPlayback, field: "enhanceDialog", path: "homeTheater/options"},
+}
+
+// requireCapability returns the player of the room when it has capability,
+// otherwise an error listing the capabilities it does have.
+func requireCapability(room Room, action string, capability string) (Player, error) {
+	stateMutex.RLock()
+	player, ok := players[room.Name]
+	stateMutex.RUnlock()
+
+	if !ok {
+		return player, fmt.Errorf("room %s not found", room.Name)
+	}
+	if !slices.Contains(player.Capabilities, capability) {
+		return player, fmt.Errorf(
+			"room %s does not support %s, which requires %s; its capabilities are: %s",
+			room.Name, action, capability, strings.Join(player.Capabilities, ", "),
+		)
+	}
+	return player, nil
+}
+
+// SetAudioSetting switches a home-theater setting of the player of the room
+// off (0) or on (1).
+func (s *Sonos) SetAudioSetting(ctx context.Context, room Room, action string, value *int) error {
+	setting := audioSettings[action]
+	if value == nil {
+		return fmt.Errorf("%s action requires a value", action)
+	}
+
+	player, err := requireCapability(room, action, setting.capability)
+	if err != nil {
+		return err
+	}
+
+	if *value != 0 && *value != 1 {
+		return fmt.Errorf("%s value must be 0 or 1", action)
+	}
+	payload := fmt.Sprintf(`{%q: %t}`, setting.field, *value == 1)
+
+	url := fmt.Sprintf("%s/players/%s/%s", baseURL, player.Id, setting.path)
+	if _, err := s.sonosAPIRequest(ctx, url, "POST", strings.NewReader(payload)); err != nil {
+		return fmt.Errorf("failed to set %s: %w", action, err)
+	}
+
+	slog.InfoContext(ctx, "Changed audio setting", "room", room.Name, "setting", action, "value", *value)
+	return nil
+}
+
+// PlayTV switches a home-theater player to its TV input.
+func (s *Sonos) PlayTV(ctx context.Context, room Room) error {
+	player, err := requireCapability(room, "tv", capabilityHomeTheaterPlayback)
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/players/%s/homeTheater", baseURL, player.Id)
+	if _, err := s.sonosAPIRequest(ctx, url, "POST", nil); err != nil {
+		return fmt.Errorf("failed to switch to TV: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Switched to TV input", "room", room.Name)
+	return nil
+}
+
+// PlayLineIn plays the line-in of the player of the room in its group.
+func (s *Sonos) PlayLineIn(ctx context.Context, room Room) error {
+	player, err := requireCapability(room, "line-in", capabilityLineIn)
+	if err != nil {
+		return err
+	}
+
+	group, ok := groupOfPlayer(player.Id)
+	if !ok {
+		return fmt.Errorf("room %s is not in any group", room.Name)
+	}
+
+	url := fmt.Sprintf("%s/groups/%s/playback/lineIn", baseURL, group.Id)
+	payload := strings.NewReader(fmt.Sprintf(`{"deviceId": %q, "playOnCompletion": true}`, player.Id))
+	if _, err := s.sonosAPIRequest(ctx, url, "POST", payload); err != nil {
+		return fmt.Errorf("failed to switch to line-in: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Switched to line-in", "room", room.Name)
+	return nil
+}
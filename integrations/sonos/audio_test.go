@@ -0,0 +1,57 @@
+package sonos
+
+import (
+	"context"
+	"cuore/common"
+	"testing"
+)
+
+func TestRequireCapability(t *testing.T) {
+	players = map[string]Player{
+		"Living Room": {Id: "p1", Capabilities: []string{"PLAYBACK", "HT_PLAYBACK", "AIRPLAY"}},
+		"Kitchen":     {Id: "p2", Capabilities: []string{"PLAYBACK", "LINE_IN"}},
+	}
+	defer func() { players = map[string]Player{} }()
+
+	tests := []struct {
+		room       string
+		action     string
+		capability string
+		err        string
+	}{
+		{"Living Room", "night-mode", capabilityHomeTheaterPlayback, ""},
+		{"Kitchen", "line-in", capabilityLineIn, ""},
+		{"Kitchen", "tv", capabilityHomeTheaterPlayback, "room Kitchen does not support tv, which requires HT_PLAYBACK; its capabilities are: PLAYBACK, LINE_IN"},
+		{"Living Room", "line-in", capabilityLineIn, "room Living Room does not support line-in, which requires LINE_IN; its capabilities are: PLAYBACK, HT_PLAYBACK, AIRPLAY"},
+		{"Office", "tv", capabilityHomeTheaterPlayback, "room Office not found"},
+	}
+
+	for _, test := range tests {
+		player, err := requireCapability(Room{Name: test.room}, test.action, test.capability)
+		if test.err == "" {
+			if err != nil || player.Id == "" {
+				t.Errorf("%s %s: expected the player, got %v", test.room, test.action, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s %s: expected error %q, got %v", test.room, test.action, test.err, err)
+		}
+	}
+}
+
+func TestEQSettingsAreNotSupported(t *testing.T) {
+	api := newFakeHousehold(t,
+		[]Group{{Id: "g1", Name: "Kitchen", CoordinatorId: "p1", PlayerIds: []string{"p1"}}},
+		[]Player{{Id: "p1", Name: "Kitchen", Capabilities: []string{"PLAYBACK"}}},
+	)
+
+	value := 3
+	err := (&Sonos{}).HandleControl(context.Background(), common.ControlMessage{Action: "bass", Room: "Kitchen", Value: &value})
+	if err == nil || err.Error() != "bass is not supported, the Sonos Control API does not expose EQ settings" {
+		t.Fatalf("expected bass to be rejected as unsupported, got %v", err)
+	}
+	if len(api.requests) != 1 {
+		t.Fatalf("expected no request besides listing the groups, got %v", api.requests)
+	}
+}
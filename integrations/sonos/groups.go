@@ -0,0 +1,143 @@
+package sonos
+
+import (
+	"cuore/config"
+	"sort"
+	"time"
+)
+
+const playbackStatePlaying = "PLAYBACK_STATE_PLAYING"
+
+// Playing reports whether the group is currently playing.
+func (g Group) Playing() bool {
+	return g.PlaybackState == playbackStatePlaying
+}
+
+// Has reports whether player is a member of the group.
+func (g Group) Has(player string) bool {
+	for _, member := range g.PlayerIds {
+		if member == player {
+			return true
+		}
+	}
+	return false
+}
+
+// With returns the members of the group with player added.
+func (g Group) With(player string) []string {
+	if g.Has(player) {
+		return append([]string{}, g.PlayerIds...)
+	}
+	return append(append([]string{}, g.PlayerIds...), player)
+}
+
+// Without returns the members of the group with player removed. Removing the
+// coordinator makes Sonos hand the playback session to a remaining member.
+func (g Group) Without(player string) []string {
+	members := make([]string, 0, len(g.PlayerIds))
+	for _, member := range g.PlayerIds {
+		if member != player {
+			members = append(members, member)
+		}
+	}
+	return members
+}
+
+// groupById returns the group with the given id.
+func groupById(id string) (Group, bool) {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+
+	group, ok := groups[id]
+	return group, ok
+}
+
+// groupOfPlayer returns the group the player is a member of.
+func groupOfPlayer(player string) (Group, bool) {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+
+	for _, group := range groups {
+		if group.Has(player) {
+			return group, true
+		}
+	}
+	return Group{}, false
+}
+
+func groupForPlayer(player string) string {
+	group, _ := groupOfPlayer(player)
+	return group.Id
+}
+
+// groupMembers returns a copy of the player ids of a group.
+func groupMembers(groupId string) []string {
+	group, _ := groupById(groupId)
+	return append([]string{}, group.PlayerIds...)
+}
+
+// findGroup resolves a room, group name or group id to a group.
+func findGroup(name string) (Group, bool) {
+	if player := playerId(name); player != "" {
+		return groupOfPlayer(player)
+	}
+
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+
+	for _, group := range groups {
+		if group.Id == name || group.Name == name {
+			return group, true
+		}
+	}
+	return Group{}, false
+}
+
+// markStarted records that the group of coordinator started playing, which
+// makes it the preferred group to join.
+func markStarted(coordinator string) {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	startedAt[coordinator] = time.Now()
+}
+
+// choosePlayingGroup picks the group to join among the playing groups the
+// player is not part of: the group SONOS_PREFERRED_GROUP resolves to (a room,
+// group name or group id) if it is playing, otherwise the group that started
+// playing most recently. Starts are seen by Play and by the now-playing poll.
+func choosePlayingGroup(player string) (Group, bool) {
+	var preferred Group
+	if name := config.Get().SonosPreferredGroup; name != "" {
+		preferred, _ = findGroup(name)
+	}
+
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+
+	var candidates []Group
+	for _, group := range groups {
+		if !group.Playing() || group.Has(player) {
+			continue
+		}
+		if preferred.Id != "" && group.Id == preferred.Id {
+			return group, true
+		}
+		candidates = append(candidates, group)
+	}
+
+	if len(candidates) == 0 {
+		return Group{}, false
+	}
+
+	// groups whose start was not observed sort last, ties by name so the
+	// choice does not depend on map order
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := startedAt[candidates[i].CoordinatorId], startedAt[candidates[j].CoordinatorId]
+		if !a.Equal(b) {
+			return a.After(b)
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	return candidates[0], true
+}
@@ -0,0 +1,225 @@
+package sonos
+
+import (
+	"context"
+	"cuore/common"
+	"cuore/config"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestChoosePlayingGroup(t *testing.T) {
+	groups = map[string]Group{
+		"g1": {Id: "g1", Name: "Kitchen", CoordinatorId: "p1", PlaybackState: playbackStatePlaying, PlayerIds: []string{"p1"}},
+		"g2": {Id: "g2", Name: "Living Room", CoordinatorId: "p2", PlaybackState: playbackStatePlaying, PlayerIds: []string{"p2"}},
+		"g3": {Id: "g3", Name: "Office", CoordinatorId: "p3", PlaybackState: "PLAYBACK_STATE_IDLE", PlayerIds: []string{"p3"}},
+		"g4": {Id: "g4", Name: "Bedroom", CoordinatorId: "p4", PlaybackState: playbackStatePlaying, PlayerIds: []string{"p4"}},
+	}
+	players = map[string]Player{"Living Room": {Id: "p2"}}
+	startedAt = map[string]time.Time{}
+	defer func() {
+		groups, players, startedAt = map[string]Group{}, map[string]Player{}, map[string]time.Time{}
+	}()
+
+	if group, _ := choosePlayingGroup("p3"); group.Id != "g4" {
+		t.Fatalf("expected ties to be broken by name, got %s", group.Name)
+	}
+
+	startedAt["p1"] = time.Now()
+	if group, _ := choosePlayingGroup("p3"); group.Id != "g1" {
+		t.Fatalf("expected the most recently started group, got %s", group.Name)
+	}
+	if group, _ := choosePlayingGroup("p1"); group.Id != "g4" {
+		t.Fatalf("expected the own group to be skipped, got %s", group.Name)
+	}
+
+	config.Get().SonosPreferredGroup = "Living Room"
+	defer func() { config.Get().SonosPreferredGroup = "" }()
+	if group, _ := choosePlayingGroup("p3"); group.Id != "g2" {
+		t.Fatalf("expected the preferred group, got %s", group.Name)
+	}
+
+	config.Get().SonosPreferredGroup = "Bedroom"
+	if group, _ := choosePlayingGroup("p3"); group.Id != "g4" {
+		t.Fatalf("expected the preferred group by group name, got %s", group.Name)
+	}
+}
+
+// fakeHousehold serves a household through a fake Control API. Regrouping
+// changes its groups, and every request is recorded as "METHOD path".
+type fakeHousehold struct {
+	mutex     sync.Mutex
+	groups    []Group
+	players   []Player
+	requests  []string
+	bodies    map[string]string // request -> last body
+	responses map[string]string // request -> body of the response, "{}" otherwise
+	// onRequest, when set, is called before a request is handled
+	onRequest func(request string)
+}
+
+func newFakeHousehold(t *testing.T, groups []Group, players []Player) *fakeHousehold {
+	t.Helper()
+	config.Get().CredentialStore = "file"
+	config.Get().EncryptionFilePath = t.TempDir()
+	config.Get().EncryptionKey = "example key 1234"
+	config.Get().SonosHouseholdId = "household"
+	config.Get().VendorRequestTimeout = time.Second
+	config.Get().VendorMaxRetries = 0
+
+	api := &fakeHousehold{groups: groups, players: players, bodies: map[string]string{}, responses: map[string]string{}}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+
+	previous := baseURL
+	baseURL = server.URL
+	t.Cleanup(func() {
+		baseURL = previous
+		resetState()
+	})
+	if err := tokens.SetToken(&oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	return api
+}
+
+func resetState() {
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	groups, players, startedAt = map[string]Group{}, map[string]Player{}, map[string]time.Time{}
+}
+
+func (f *fakeHousehold) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request := r.Method + " " + r.URL.Path
+	if f.onRequest != nil {
+		f.onRequest(request)
+	}
+	body, _ := io.ReadAll(r.Body)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.requests = append(f.requests, request)
+	f.bodies[request] = string(body)
+
+	switch {
+	case request == "GET /households/household/groups":
+		json.NewEncoder(w).Encode(GroupsResponse{Groups: f.groups, Players: f.players})
+	case strings.HasSuffix(request, "/groups/setGroupMembers"):
+		var members struct{ PlayerIds []string }
+		json.Unmarshal(body, &members)
+		f.setGroupMembers(strings.Split(r.URL.Path, "/")[2], members.PlayerIds)
+		w.Write([]byte("{}"))
+	case f.responses[request] != "":
+		w.Write([]byte(f.responses[request]))
+	default:
+		w.Write([]byte("{}"))
+	}
+}
+
+// setGroupMembers regroups like Sonos: the members leave their groups, the
+// players left out get a group of their own, and a group whose coordinator
+// left is led by its first remaining member.
+func (f *fakeHousehold) setGroupMembers(groupId string, members []string) {
+	var regrouped []Group
+	grouped := map[string]bool{}
+	for _, group := range f.groups {
+		if group.Id == groupId {
+			group.PlayerIds = members
+		} else {
+			group.PlayerIds = slices.DeleteFunc(slices.Clone(group.PlayerIds), func(player string) bool {
+				return slices.Contains(members, player)
+			})
+		}
+		if len(group.PlayerIds) == 0 {
+			continue
+		}
+		if !slices.Contains(group.PlayerIds, group.CoordinatorId) {
+			group.CoordinatorId = group.PlayerIds[0]
+		}
+		for _, player := range group.PlayerIds {
+			grouped[player] = true
+		}
+		regrouped = append(regrouped, group)
+	}
+	for _, player := range f.players {
+		if !grouped[player.Id] {
+			regrouped = append(regrouped, Group{Id: "g-" + player.Id, Name: player.Name, CoordinatorId: player.Id, PlayerIds: []string{player.Id}})
+		}
+	}
+	f.groups = regrouped
+}
+
+func (f *fakeHousehold) sent(request string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return slices.Contains(f.requests, request)
+}
+
+// members returns the members of the group of player.
+func (f *fakeHousehold) members(player string) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, group := range f.groups {
+		if slices.Contains(group.PlayerIds, player) {
+			return group.PlayerIds
+		}
+	}
+	return nil
+}
+
+func TestRegroupBlocksSingleRoomCommands(t *testing.T) {
+	api := newFakeHousehold(t,
+		[]Group{
+			{Id: "g1", Name: "Kitchen", CoordinatorId: "p1", PlaybackState: playbackStatePlaying, PlayerIds: []string{"p1"}},
+			{Id: "g2", Name: "Office", CoordinatorId: "p2", PlayerIds: []string{"p2"}},
+		},
+		[]Player{{Id: "p1", Name: "Kitchen"}, {Id: "p2", Name: "Office"}},
+	)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	defer unblock()
+	api.onRequest = func(request string) {
+		if request == "POST /groups/g1/groups/setGroupMembers" {
+			close(started)
+			<-release
+		}
+	}
+
+	s := &Sonos{}
+	ctx := context.Background()
+	done := make(chan error, 2)
+	go func() {
+		done <- s.HandleControl(ctx, common.ControlMessage{Action: "join", Room: "Office", To: "Kitchen"})
+	}()
+	<-started
+	go func() {
+		done <- s.HandleControl(ctx, common.ControlMessage{Action: "pause", Room: "Kitchen"})
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if api.sent("POST /groups/g1/playback/pause") {
+		t.Fatal("expected the pause to wait for the regroup")
+	}
+
+	unblock()
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !api.sent("POST /groups/g1/playback/pause") {
+		t.Fatal("expected the pause to run after the regroup")
+	}
+}
@@ -7,19 +7,24 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 )
 
 var (
-	baseURL      = "https://api.ws.sonos.com/control/api/v1"
-	groups       = map[string]Group{}
-	players      = map[string]Player{}
-	groupPlayers = map[string][]string{}
-	roomMutex    sync.Mutex
+	baseURL = "https://api.ws.sonos.com/control/api/v1"
+	groups  = map[string]Group{}  // groupId -> group
+	players = map[string]Player{} // room -> player
+	// startedAt holds when the group of a coordinator was last seen to start
+	// playing
+	startedAt = map[string]time.Time{}
+	// stateMutex guards groups, players and startedAt, as commands for
+	// different rooms run concurrently
+	stateMutex sync.RWMutex
 )
 
 // Add these types to match the API response structure
@@ -51,11 +56,6 @@ type Player struct {
 	DeviceIds      []string `json:"deviceIds"`
 }
 
-func authenticationToken() string {
-	token, _ := getToken()
-	return fmt.Sprintf("Bearer %s", token.AccessToken)
-}
-
 func (s *Sonos) playerOrGroup() string {
 	if s.ControlPlayers {
 		return "player"
@@ -63,56 +63,98 @@ func (s *Sonos) playerOrGroup() string {
 	return "group"
 }
 
-func (s *Sonos) findRoom(roomName string) *Room {
+func (s *Sonos) findOrCreateRoom(roomName string) Room {
+	s.roomsMutex.Lock()
+	defer s.roomsMutex.Unlock()
+
 	for _, room := range s.Rooms {
 		if room.Name == roomName {
-			return &room
+			return room
 		}
 	}
-	return nil
+
+	// room does not exist yet, creating new room
+	room := Room{
+		Name: roomName,
+	}
+	s.Rooms = append(s.Rooms, room)
+	return room
 }
 
-func (s *Sonos) sonosAPIRequest(url string, method string, payload io.Reader) (*http.Response, error) {
-	req, _ := http.NewRequest(method, url, payload)
-	token, _ := getToken()
+func playerId(roomName string) string {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
 
-	if token == nil || token.Expiry.Before(time.Now()) {
-		log.Print("Token expired, refreshing")
-		// If the access token is expired or not yet obtained, use the refresh token to get a new one
-		tokenSource := getAuthConfig().TokenSource(context.Background(), token)
+	return players[roomName].Id
+}
 
-		newToken, err := tokenSource.Token()
-		if err != nil {
-			// Handle error, e.g., log out the user or prompt for reauthentication
-			return nil, err
-		}
-		setToken(newToken)
+// client sends all requests to the Sonos Control API.
+var client = newClient()
+
+func newClient() *common.VendorClient {
+	client := common.NewVendorClient("Sonos")
+	client.ErrorMessage = errorMessage
+	return client
+}
+
+// errorResponse covers both the errors of the Control API and the faults of
+// the gateway in front of it.
+type errorResponse struct {
+	ErrorCode string `json:"errorCode"`
+	Reason    string `json:"reason"`
+	Fault     *struct {
+		FaultString string `json:"faultstring"`
+	} `json:"fault"`
+}
+
+func errorMessage(body []byte) string {
+	var response errorResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return strings.TrimSpace(string(body))
+	}
+
+	switch {
+	case response.Fault != nil:
+		return response.Fault.FaultString
+	case response.Reason != "":
+		return fmt.Sprintf("%s (%s)", response.Reason, response.ErrorCode)
+	default:
+		return response.ErrorCode
+	}
+}
+
+// sonosAPIRequest sends a request to the Control API and returns the body of
+// the response.
+func (s *Sonos) sonosAPIRequest(ctx context.Context, url string, method string, payload io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// the token manager refreshes an expired token on its own
+	token, err := tokens.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("no valid Sonos token: %w", err)
 	}
 
 	req.Header.Add("accept", "application/json")
 	req.Header.Add("content-type", "application/json")
-	req.Header.Add("Authorization", authenticationToken())
+	token.SetAuthHeader(req)
 
-	return http.DefaultClient.Do(req)
+	return client.Do(req)
 }
 
-func (s *Sonos) discoverHouseholds() error {
+func (s *Sonos) discoverHouseholds(ctx context.Context) error {
 	url := fmt.Sprintf(
 		"%s/households",
 		baseURL,
 	)
-	res, err := s.sonosAPIRequest(url, "GET", nil)
-
-	if err != nil || res.StatusCode != 200 {
-		log.Printf("Failed to make request to Sonos Households API, %v, %v", res.StatusCode, err)
-		return err
+	body, err := s.sonosAPIRequest(ctx, url, "GET", nil)
+	if err != nil {
+		return fmt.Errorf("failed to discover households: %w", err)
 	}
 
-	body, _ := io.ReadAll(res.Body)
-	fmt.Println(string(body))
-
-	defer res.Body.Close()
-
+	slog.InfoContext(ctx, "Found Sonos households", "households", string(body))
 	return nil
 }
 
@@ -121,25 +163,15 @@ func (s *Sonos) setHousehold(householdId string) error {
 	return nil
 }
 
-func (s *Sonos) updateGroupsAndPlayers() error {
+func (s *Sonos) updateGroupsAndPlayers(ctx context.Context) error {
 	url := fmt.Sprintf(
 		"%s/households/%s/groups",
 		baseURL,
 		config.Get().SonosHouseholdId,
 	)
-	res, err := s.sonosAPIRequest(url, "GET", nil)
+	body, err := s.sonosAPIRequest(ctx, url, "GET", nil)
 	if err != nil {
-		return fmt.Errorf("failed to make request to Sonos Groups API: %w", err)
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != 200 {
-		return fmt.Errorf("unexpected status code %d from Sonos API", res.StatusCode)
-	}
-
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return fmt.Errorf("failed to update groups: %w", err)
 	}
 
 	var response GroupsResponse
@@ -147,14 +179,28 @@ func (s *Sonos) updateGroupsAndPlayers() error {
 		return fmt.Errorf("error decoding JSON: %w", err)
 	}
 
-	// Clear existing maps before updating
-	groupPlayers = make(map[string][]string)
+	common.RecordStateSync(providerName)
+	s.discovered.Store(true)
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+
+	wasPlaying := map[string]bool{}
+	for _, group := range groups {
+		wasPlaying[group.CoordinatorId] = group.Playing()
+	}
+	initialSync := len(groups) == 0
 
-	// Update groups and their players
+	// Replace the groups, as group ids change with every regrouping
+	groups = make(map[string]Group)
 	for _, group := range response.Groups {
-		groups[group.Name] = group
-		groupPlayers[group.Id] = group.PlayerIds
-		log.Printf("🔈 Found group: %s with %d players", group.Name, len(group.PlayerIds))
+		groups[group.Id] = group
+		// a new coordinator after regrouping also counts as a start, except
+		// for the groups found on the first sync
+		if playing, seen := wasPlaying[group.CoordinatorId]; group.Playing() && !playing && (seen || !initialSync) {
+			startedAt[group.CoordinatorId] = time.Now()
+		}
+		slog.DebugContext(ctx, "Found Sonos group", "group", group.Name, "players", len(group.PlayerIds))
 	}
 
 	// Update players
@@ -165,60 +211,110 @@ func (s *Sonos) updateGroupsAndPlayers() error {
 	return nil
 }
 
-func groupForPlayer(player string) string {
-	for group, players := range groupPlayers {
-		for _, p := range players {
-			if p == player {
-				return group
-			}
-		}
-	}
+// controlActions are the actions handled by HandleControl.
+var controlActions = []string{
+	"play", "pause", "volume", "join", "leave", "solo", "transfer",
+	"group-preset", "ungroup-all", "sleep", "sleep-cancel",
+	"night-mode", "speech-enhancement",
+	"tv", "line-in", "play-url", "snapshot", "restore",
+}
 
-	return ""
+func (s *Sonos) Actions() []string {
+	return controlActions
 }
 
-func (s *Sonos) HandleControl(msg common.ControlMessage) error {
-	roomMutex.Lock()
-	defer roomMutex.Unlock()
+// multiRoomActions change the groups of other rooms than the one of the
+// message. Besides being queued together, they hold regroupMutex
+// exclusively, so commands for single rooms, which are queued per room,
+// never run while the groups change under them.
+var multiRoomActions = []string{"group-preset", "ungroup-all", "transfer", "join", "leave", "solo", "restore"}
 
-	s.updateGroupsAndPlayers()
+var regroupMutex sync.RWMutex
 
-	var room Room
-	if r := s.findRoom(msg.Room); r == nil {
-		// room does not exist yet, creating new room
-		room = Room{
-			Name: msg.Room,
-		}
-		s.Rooms = append(s.Rooms, room)
+func (s *Sonos) MultiRoom(action string) bool {
+	return slices.Contains(multiRoomActions, action)
+}
+
+func (s *Sonos) HandleControl(ctx context.Context, msg common.ControlMessage) error {
+	if s.MultiRoom(msg.Action) {
+		regroupMutex.Lock()
+		defer regroupMutex.Unlock()
 	} else {
-		room = *r
+		regroupMutex.RLock()
+		defer regroupMutex.RUnlock()
 	}
 
+	s.updateGroupsAndPlayers(ctx)
+
+	// presets span several rooms, msg.Room optionally picks the coordinator
+	switch msg.Action {
+	case "group-preset":
+		if msg.Name == "" {
+			return fmt.Errorf("group-preset action requires a preset name")
+		}
+		return s.ApplyPreset(ctx, msg.Name, msg.Room, msg.Value)
+	case "ungroup-all":
+		return s.UngroupAll(ctx)
+	}
+
+	room := s.findOrCreateRoom(msg.Room)
+
 	switch msg.Action {
 	case "play":
-		return s.Play(room)
+		return s.Play(ctx, room)
 	case "pause":
-		return s.Pause(room)
+		return s.Pause(ctx, room)
 	case "volume":
 		if msg.Value == nil {
 			return fmt.Errorf("volume action requires a value")
 		}
-		return s.SetVolume(*msg.Value, room)
+		return s.SetVolume(ctx, *msg.Value, room)
 	case "join":
-		return s.JoinPlayingGroup(room)
+		return s.JoinPlayingGroup(ctx, room, msg.To)
 	case "leave":
-		return s.LeaveGroup(room)
+		return s.LeaveGroup(ctx, room)
 	case "solo":
-		return s.PlaySolo(room)
+		return s.PlaySolo(ctx, room)
+	case "transfer":
+		if msg.To == "" {
+			return fmt.Errorf("transfer action requires a target room")
+		}
+		return s.Transfer(ctx, room, s.findOrCreateRoom(msg.To))
+	case "sleep":
+		if msg.Value == nil {
+			return fmt.Errorf("sleep action requires a number of minutes")
+		}
+		return s.Sleep(ctx, room, *msg.Value)
+	case "sleep-cancel":
+		return s.CancelSleep(ctx, room)
+	case "night-mode", "speech-enhancement":
+		return s.SetAudioSetting(ctx, room, msg.Action, msg.Value)
+	case "bass", "treble", "loudness":
+		// the playerSettings namespace only takes volumeMode,
+		// volumeScalingFactor, monoMode and wifiDisable
+		return fmt.Errorf("%s is not supported, the Sonos Control API does not expose EQ settings", msg.Action)
+	case "play-url":
+		if msg.URL == "" {
+			return fmt.Errorf("play-url action requires a url")
+		}
+		return s.PlayURL(ctx, room, msg.URL, msg.Title, msg.Artist)
+	case "tv":
+		return s.PlayTV(ctx, room)
+	case "line-in":
+		return s.PlayLineIn(ctx, room)
+	case "snapshot":
+		return s.Snapshot(ctx, room, msg.Name)
+	case "restore":
+		return s.Restore(ctx, room, msg.Name)
 	default:
 		return fmt.Errorf("unknown action: %s", msg.Action)
 	}
 }
 
-func (s *Sonos) HandleSetup(msg common.SetupMessage) error {
+func (s *Sonos) HandleSetup(ctx context.Context, msg common.SetupMessage) error {
 	switch msg.Command {
 	case "discover-households":
-		return s.discoverHouseholds()
+		return s.discoverHouseholds(ctx)
 	case "set-household":
 		return s.setHousehold(msg.Value)
 	default:
@@ -226,57 +322,67 @@ func (s *Sonos) HandleSetup(msg common.SetupMessage) error {
 	}
 }
 
-func (s *Sonos) Play(room Room) error {
+func (s *Sonos) Play(ctx context.Context, room Room) error {
+	group, ok := groupOfPlayer(playerId(room.Name))
+	if !ok {
+		return fmt.Errorf("room %s is not in any group", room.Name)
+	}
+
 	url := fmt.Sprintf(
 		"%s/groups/%v/playback/play",
 		baseURL,
-		groupForPlayer(players[room.Name].Id),
+		group.Id,
 	)
 
-	_, err := s.sonosAPIRequest(url, "POST", nil)
-	log.Print("🔈 Start playing music in room ", room.Name)
-	return err
+	if _, err := s.sonosAPIRequest(ctx, url, "POST", nil); err != nil {
+		return fmt.Errorf("failed to play: %w", err)
+	}
+	markStarted(group.CoordinatorId)
+
+	slog.InfoContext(ctx, "Started playing music", "room", room.Name)
+	return nil
 }
 
-func (s *Sonos) Pause(room Room) error {
+func (s *Sonos) Pause(ctx context.Context, room Room) error {
+	group, ok := groupOfPlayer(playerId(room.Name))
+	if !ok {
+		return fmt.Errorf("room %s is not in any group", room.Name)
+	}
+
 	url := fmt.Sprintf(
 		"%s/groups/%s/playback/pause",
 		baseURL,
-		groupForPlayer(players[room.Name].Id),
+		group.Id,
 	)
 
-	_, err := s.sonosAPIRequest(url, "POST", nil)
-	log.Print("🔈 Pause music in room ", room.Name)
-	return err
+	if _, err := s.sonosAPIRequest(ctx, url, "POST", nil); err != nil {
+		return fmt.Errorf("failed to pause: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Paused music", "room", room.Name)
+	return nil
 }
 
-func (s *Sonos) SetVolume(value int, room Room) error {
+func (s *Sonos) SetVolume(ctx context.Context, value int, room Room) error {
 	url := fmt.Sprintf(
 		"%s/%ss/%s/%sVolume",
 		baseURL,
 		s.playerOrGroup(),
-		players[room.Name].Id,
+		playerId(room.Name),
 		s.playerOrGroup(),
 	)
 
 	payload := strings.NewReader(fmt.Sprintf("{\"volume\":%d}", value))
 
-	res, err := s.sonosAPIRequest(url, "POST", payload)
-	if err != nil {
-		return fmt.Errorf("failed to make request to Sonos API: %w", err)
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode != 200 {
-		body, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("failed to set volume: %s", string(body))
+	if _, err := s.sonosAPIRequest(ctx, url, "POST", payload); err != nil {
+		return fmt.Errorf("failed to set volume: %w", err)
 	}
 
-	log.Printf("🔈 Volume changed to %d for %s", value, room.Name)
+	slog.InfoContext(ctx, "Changed volume", "room", room.Name, "volume", value)
 	return nil
 }
 
-func (s *Sonos) setGroupMembers(groupId string, members []string) error {
+func (s *Sonos) setGroupMembers(ctx context.Context, groupId string, members []string) error {
 	url := fmt.Sprintf(
 		"%s/groups/%s/groups/setGroupMembers",
 		baseURL,
@@ -285,85 +391,125 @@ func (s *Sonos) setGroupMembers(groupId string, members []string) error {
 
 	payload := strings.NewReader(fmt.Sprintf(`{"playerIds": %s}`, marshalPlayerIds(members)))
 
-	res, err := s.sonosAPIRequest(url, "POST", payload)
-	if err != nil {
-		return fmt.Errorf("failed to make request to Sonos API: %w", err)
+	if _, err := s.sonosAPIRequest(ctx, url, "POST", payload); err != nil {
+		return fmt.Errorf("failed to set group members: %w", err)
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != 200 {
-		body, _ := io.ReadAll(res.Body)
-		return fmt.Errorf("failed to set group members: %s", string(body))
-	}
-
-	return s.updateGroupsAndPlayers() // Refresh our local state
+	return s.updateGroupsAndPlayers(ctx) // Refresh our local state
 }
 
-func (s *Sonos) JoinPlayingGroup(room Room) error {
-	// Find the currently playing group
-	playingGroupId, err := s.GetPlayingGroup()
-	fmt.Println(playingGroupId)
-	if err != nil {
-		return fmt.Errorf("failed to get playing group: %w", err)
+// JoinPlayingGroup adds the room to the group of to, which may be a room, a
+// group name or a group id. Without to, it joins the preferred or most
+// recently started playing group.
+func (s *Sonos) JoinPlayingGroup(ctx context.Context, room Room, to string) error {
+	player := playerId(room.Name)
+	if player == "" {
+		return fmt.Errorf("room %s not found", room.Name)
 	}
-	if playingGroupId == "" {
-		return fmt.Errorf("no group is currently playing")
+
+	var group Group
+	var ok bool
+	if to != "" {
+		if group, ok = findGroup(to); !ok {
+			return fmt.Errorf("no room or group named %s", to)
+		}
+	} else if group, ok = choosePlayingGroup(player); !ok {
+		return fmt.Errorf("no other group is currently playing")
 	}
 
-	// Get current group members
-	currentMembers := groupPlayers[playingGroupId]
-	fmt.Println(currentMembers)
+	if err := s.joinGroup(ctx, group, player); err != nil {
+		return err
+	}
 
-	// Add the new player to the members list if not already present
-	playerToAdd := players[room.Name].Id
-	for _, member := range currentMembers {
-		if member == playerToAdd {
-			return nil // Already in group
-		}
+	slog.InfoContext(ctx, "Joined group", "room", room.Name, "group", group.Name)
+	return nil
+}
+
+// joinGroup adds player to group unless it is already a member.
+func (s *Sonos) joinGroup(ctx context.Context, group Group, player string) error {
+	if group.Has(player) {
+		return nil
 	}
-	fmt.Println(playerToAdd)
-	currentMembers = append(currentMembers, playerToAdd)
-	fmt.Println(currentMembers)
 
-	if err := s.setGroupMembers(playingGroupId, currentMembers); err != nil {
+	if err := s.setGroupMembers(ctx, group.Id, group.With(player)); err != nil {
 		return fmt.Errorf("failed to join group: %w", err)
 	}
-
-	log.Printf("🔈 Room %s joined the playing group", room.Name)
 	return nil
 }
 
-func (s *Sonos) LeaveGroup(room Room) error {
-	playerId := players[room.Name].Id
-	if playerId == "" {
+// LeaveGroup removes the room from its group. When the room is the
+// coordinator, the remaining players keep playing.
+func (s *Sonos) LeaveGroup(ctx context.Context, room Room) error {
+	player := playerId(room.Name)
+	if player == "" {
 		return fmt.Errorf("room %s not found", room.Name)
 	}
 
-	// Find which group this player is in
-	currentGroupId := groupForPlayer(playerId)
-	if currentGroupId == "" {
+	group, ok := groupOfPlayer(player)
+	if !ok {
 		return fmt.Errorf("room %s is not in any group", room.Name)
 	}
-
-	// Get current group members
-	currentMembers := groupPlayers[currentGroupId]
-	if len(currentMembers) <= 1 {
+	if len(group.PlayerIds) <= 1 {
 		return fmt.Errorf("room %s is the only member of its group", room.Name)
 	}
 
-	// Remove the player from the members list
-	newMembers := make([]string, 0, len(currentMembers)-1)
-	for _, member := range currentMembers {
-		if member != playerId {
-			newMembers = append(newMembers, member)
-		}
+	if err := s.setGroupMembers(ctx, group.Id, group.Without(player)); err != nil {
+		return fmt.Errorf("failed to leave group: %w", err)
 	}
 
-	if err := s.setGroupMembers(currentGroupId, newMembers); err != nil {
-		return fmt.Errorf("failed to leave group: %w", err)
+	slog.InfoContext(ctx, "Left group", "room", room.Name)
+	return nil
+}
+
+// Transfer moves the music of the source room to the target room: the target
+// joins the group of the source with the volume of the source, then the
+// source leaves. Playback continues with the current track and position.
+func (s *Sonos) Transfer(ctx context.Context, source Room, target Room) error {
+	sourcePlayer := playerId(source.Name)
+	if sourcePlayer == "" {
+		return fmt.Errorf("room %s not found", source.Name)
+	}
+	if target.Name == source.Name {
+		return fmt.Errorf("cannot transfer music from %s to itself", source.Name)
+	}
+
+	targetPlayer := playerId(target.Name)
+	if targetPlayer == "" {
+		return fmt.Errorf("room %s not found", target.Name)
+	}
+
+	group, ok := groupOfPlayer(sourcePlayer)
+	if !ok {
+		return fmt.Errorf("room %s is not in any group", source.Name)
+	}
+	playing := group.Playing()
+
+	volume, err := s.getPlayerVolume(ctx, sourcePlayer)
+	if err != nil {
+		return fmt.Errorf("failed to read volume of %s: %w", source.Name, err)
+	}
+
+	if err := s.joinGroup(ctx, group, targetPlayer); err != nil {
+		return err
+	}
+	if err := s.setPlayerVolume(ctx, targetPlayer, volume); err != nil {
+		return fmt.Errorf("failed to carry over volume: %w", err)
 	}
 
-	log.Printf("🔈 Room %s left its group", room.Name)
+	// When the coordinator leaves, Sonos hands the playback session over to
+	// one of the remaining players, which gives the group a new id.
+	if err := s.LeaveGroup(ctx, source); err != nil {
+		return err
+	}
+
+	if playing && group.CoordinatorId == sourcePlayer {
+		url := fmt.Sprintf("%s/groups/%s/playback/play", baseURL, groupForPlayer(targetPlayer))
+		if _, err := s.sonosAPIRequest(ctx, url, "POST", nil); err != nil {
+			return fmt.Errorf("failed to resume playback: %w", err)
+		}
+	}
+
+	slog.InfoContext(ctx, "Transferred music", "from", source.Name, "to", target.Name)
 	return nil
 }
 
@@ -376,45 +522,32 @@ func marshalPlayerIds(playerIds []string) string {
 	return string(bytes)
 }
 
-// Add this method to get the currently playing group
-func (s *Sonos) GetPlayingGroup() (string, error) {
-	// Look through the groups we already have
-	for _, group := range groups {
-		if group.PlaybackState == "PLAYBACK_STATE_PLAYING" {
-			return group.Id, nil
-		}
-	}
-
-	return "", nil // No group is currently playing
-}
-
-func (s *Sonos) PlaySolo(room Room) error {
-	playerId := players[room.Name].Id
+func (s *Sonos) PlaySolo(ctx context.Context, room Room) error {
+	playerId := playerId(room.Name)
 	if playerId == "" {
 		return fmt.Errorf("room %s not found", room.Name)
 	}
 
-	// Find which group this player is in
-	currentGroupId := groupForPlayer(playerId)
-	if currentGroupId == "" {
+	// Find which group this player is in, and whether it is playing before
+	// we change groups
+	group, ok := groupOfPlayer(playerId)
+	if !ok {
 		return fmt.Errorf("room %s is not in any group", room.Name)
 	}
-
-	// Get the current playback state before we change groups
-	isPlaying := groups[room.Name].PlaybackState == "PLAYBACK_STATE_PLAYING"
+	isPlaying := group.Playing()
 
 	// Create a new group with just this player
-	if err := s.setGroupMembers(currentGroupId, []string{playerId}); err != nil {
+	if err := s.setGroupMembers(ctx, group.Id, []string{playerId}); err != nil {
 		return fmt.Errorf("failed to set solo group: %w", err)
 	}
 
 	// If it was playing before, ensure it continues playing
 	if isPlaying {
-		if err := s.Play(room); err != nil {
+		if err := s.Play(ctx, room); err != nil {
 			return fmt.Errorf("failed to resume playback: %w", err)
 		}
 	}
 
-	log.Printf("🔈 Room %s is now playing solo", room.Name)
+	slog.InfoContext(ctx, "Playing solo", "room", room.Name)
 	return nil
 }
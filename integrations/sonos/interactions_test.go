@@ -0,0 +1,49 @@
+package sonos
+
+import (
+	"context"
+	"cuore/common"
+	"strings"
+	"testing"
+)
+
+func TestTransferWithinGroup(t *testing.T) {
+	api := newFakeHousehold(t,
+		[]Group{{Id: "g1", Name: "Kitchen + 1", CoordinatorId: "p1", PlaybackState: playbackStatePlaying, PlayerIds: []string{"p1", "p2"}}},
+		[]Player{{Id: "p1", Name: "Kitchen"}, {Id: "p2", Name: "Office"}},
+	)
+	api.responses["GET /players/p1/playerVolume"] = `{"volume": 30, "muted": false}`
+
+	err := (&Sonos{}).HandleControl(context.Background(), common.ControlMessage{Action: "transfer", Room: "Kitchen", To: "Office"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the target is already a member, so the only regroup is the source leaving
+	if body := api.bodies["POST /groups/g1/groups/setGroupMembers"]; body != `{"playerIds": ["p2"]}` {
+		t.Fatalf("expected the source to leave the group, got %q", body)
+	}
+	if body := api.bodies["POST /players/p2/playerVolume"]; body != `{"volume": 30, "muted": false}` {
+		t.Fatalf("expected the volume of the source to carry over, got %q", body)
+	}
+	if !api.sent("POST /groups/g1/playback/play") {
+		t.Fatal("expected playback to resume in the target room")
+	}
+}
+
+func TestTransferToUnknownRoom(t *testing.T) {
+	api := newFakeHousehold(t,
+		[]Group{{Id: "g1", Name: "Kitchen", CoordinatorId: "p1", PlaybackState: playbackStatePlaying, PlayerIds: []string{"p1"}}},
+		[]Player{{Id: "p1", Name: "Kitchen"}},
+	)
+
+	err := (&Sonos{}).HandleControl(context.Background(), common.ControlMessage{Action: "transfer", Room: "Kitchen", To: "Garage"})
+	if err == nil || err.Error() != "room Garage not found" {
+		t.Fatalf("expected the unknown room to be rejected, got %v", err)
+	}
+	for _, request := range api.requests {
+		if strings.HasPrefix(request, "POST") {
+			t.Fatalf("expected nothing to change, got %s", request)
+		}
+	}
+}
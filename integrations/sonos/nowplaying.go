@@ -0,0 +1,210 @@
+package sonos
+
+import (
+	"context"
+	"cuore/common"
+	"cuore/config"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// NowPlaying describes what a group is playing. Position is the position at
+// UpdatedAt, advanced to the time of the request while playing.
+type NowPlaying struct {
+	Track     string    `json:"track,omitempty"`
+	Artist    string    `json:"artist,omitempty"`
+	Album     string    `json:"album,omitempty"`
+	Service   string    `json:"service,omitempty"`
+	Container string    `json:"container,omitempty"`
+	AlbumArt  string    `json:"albumArt,omitempty"`
+	Position  int       `json:"positionMillis"`
+	Duration  int       `json:"durationMillis,omitempty"`
+	Playing   bool      `json:"isPlaying"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// sameItem reports whether n and other only differ in position.
+func (n NowPlaying) sameItem(other NowPlaying) bool {
+	n.Position, other.Position = 0, 0
+	n.UpdatedAt, other.UpdatedAt = time.Time{}, time.Time{}
+	return n == other
+}
+
+type playbackStatus struct {
+	PlaybackState  string `json:"playbackState"`
+	PositionMillis int    `json:"positionMillis"`
+}
+
+var nowPlaying = struct {
+	mutex     sync.RWMutex
+	groups    map[string]NowPlaying // groupId -> now playing
+	published map[string]NowPlaying // room -> last published
+}{groups: map[string]NowPlaying{}, published: map[string]NowPlaying{}}
+
+// NowPlaying returns what the group of the room is playing.
+func (s *Sonos) NowPlaying(room string) (any, bool) {
+	group, ok := groupOfPlayer(playerId(room))
+	if !ok {
+		return nil, false
+	}
+
+	nowPlaying.mutex.RLock()
+	current, ok := nowPlaying.groups[group.Id]
+	nowPlaying.mutex.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	if current.Playing {
+		current.Position += int(time.Since(current.UpdatedAt).Milliseconds())
+		if current.Duration > 0 {
+			current.Position = min(current.Position, current.Duration)
+		}
+	}
+	return current, true
+}
+
+// pollNowPlaying tracks the playback metadata of all groups every
+// SONOS_NOW_PLAYING_INTERVAL and publishes changes per room. Each poll lists
+// the groups and makes two requests per group that is playing or has just
+// stopped; idle groups keep what they played last.
+func (s *Sonos) pollNowPlaying(ctx context.Context) {
+	ticker := time.NewTicker(config.Get().SonosNowPlayingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !s.discovered.Load() {
+			continue
+		}
+
+		pollCtx, cancel := context.WithTimeout(common.WithTraceId(ctx), config.Get().CommandTimeout)
+		if err := s.updateNowPlaying(pollCtx); err != nil && ctx.Err() == nil {
+			slog.DebugContext(pollCtx, "Error updating now playing", "error", err)
+		}
+		cancel()
+	}
+}
+
+func (s *Sonos) updateNowPlaying(ctx context.Context) error {
+	if err := s.updateGroupsAndPlayers(ctx); err != nil {
+		return err
+	}
+
+	stateMutex.RLock()
+	current := make([]Group, 0, len(groups))
+	for _, group := range groups {
+		current = append(current, group)
+	}
+	rooms := make(map[string]string, len(players)) // playerId -> room
+	for name, player := range players {
+		rooms[player.Id] = name
+	}
+	stateMutex.RUnlock()
+
+	nowPlaying.mutex.RLock()
+	previous := nowPlaying.groups
+	nowPlaying.mutex.RUnlock()
+
+	updated := make(map[string]NowPlaying, len(current))
+	for _, group := range current {
+		if last, ok := previous[group.Id]; ok && !last.Playing && !group.Playing() {
+			updated[group.Id] = last
+			continue
+		}
+		playing, err := s.fetchNowPlaying(ctx, group.Id)
+		if err != nil {
+			return fmt.Errorf("failed to update now playing of %s: %w", group.Name, err)
+		}
+		updated[group.Id] = playing
+	}
+
+	nowPlaying.mutex.Lock()
+	nowPlaying.groups = updated
+	type update struct {
+		room    string
+		playing NowPlaying
+	}
+	var changed []update
+	for _, group := range current {
+		playing := updated[group.Id]
+		for _, player := range group.PlayerIds {
+			room, ok := rooms[player]
+			if !ok {
+				continue
+			}
+			if previous, ok := nowPlaying.published[room]; ok && previous.sameItem(playing) {
+				continue
+			}
+			nowPlaying.published[room] = playing
+			changed = append(changed, update{room, playing})
+		}
+	}
+	nowPlaying.mutex.Unlock()
+
+	for _, u := range changed {
+		common.PublishState(ctx, musicTarget, u.room, "now-playing", u.playing)
+	}
+	return nil
+}
+
+func (s *Sonos) fetchNowPlaying(ctx context.Context, groupId string) (NowPlaying, error) {
+	metadata, err := s.getPlaybackMetadata(ctx, groupId)
+	if err != nil {
+		return NowPlaying{}, err
+	}
+
+	url := fmt.Sprintf("%s/groups/%s/playback", baseURL, groupId)
+	body, err := s.sonosAPIRequest(ctx, url, "GET", nil)
+	if err != nil {
+		return NowPlaying{}, err
+	}
+
+	var status playbackStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return NowPlaying{}, fmt.Errorf("error decoding JSON: %w", err)
+	}
+
+	playing := NowPlaying{
+		Position:  status.PositionMillis,
+		Playing:   status.PlaybackState == playbackStatePlaying,
+		UpdatedAt: time.Now(),
+	}
+
+	imageUrl := ""
+	if c := metadata.Container; c != nil {
+		playing.Container = c.Name
+		imageUrl = c.ImageUrl
+		if c.Service != nil {
+			playing.Service = c.Service.Name
+		}
+	}
+	if item := metadata.CurrentItem; item != nil && item.Track != nil {
+		t := item.Track
+		playing.Track = t.Name
+		playing.Duration = t.DurationMillis
+		if t.Artist != nil {
+			playing.Artist = t.Artist.Name
+		}
+		if t.Album != nil {
+			playing.Album = t.Album.Name
+		}
+		if t.Service != nil {
+			playing.Service = t.Service.Name
+		}
+		if t.ImageUrl != "" {
+			imageUrl = t.ImageUrl
+		}
+	}
+	playing.AlbumArt = common.AlbumArtURL(imageUrl)
+
+	return playing, nil
+}
@@ -0,0 +1,89 @@
+package sonos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// appId identifies the playback sessions cuore creates.
+const appId = "com.cuore.stream"
+
+type sessionResponse struct {
+	SessionId string `json:"sessionId"`
+}
+
+// PlayURL loads an HTTP(S) stream or file into a new playback session of the
+// group of the room and starts playing it. Title and artist are shown as the
+// station name by the Sonos apps.
+func (s *Sonos) PlayURL(ctx context.Context, room Room, streamUrl string, title string, artist string) error {
+	parsed, err := url.Parse(streamUrl)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("play-url action requires an http or https URL")
+	}
+
+	group, ok := groupOfPlayer(playerId(room.Name))
+	if !ok {
+		return fmt.Errorf("room %s is not in any group", room.Name)
+	}
+
+	sessionId, err := s.createSession(ctx, group.Id)
+	if err != nil {
+		return err
+	}
+
+	name := title
+	if name == "" {
+		name = parsed.Host
+	}
+	if artist != "" {
+		name += " - " + artist
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"streamUrl":        streamUrl,
+		"playOnCompletion": false,
+		"stationMetadata":  map[string]string{"name": name},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode stream: %w", err)
+	}
+
+	loadUrl := fmt.Sprintf("%s/playbackSessions/%s/playbackSession/loadStreamUrl", baseURL, sessionId)
+	if _, err := s.sonosAPIRequest(ctx, loadUrl, "POST", strings.NewReader(string(payload))); err != nil {
+		return fmt.Errorf("failed to load stream: %w", err)
+	}
+
+	playUrl := fmt.Sprintf("%s/groups/%s/playback/play", baseURL, group.Id)
+	if _, err := s.sonosAPIRequest(ctx, playUrl, "POST", nil); err != nil {
+		return fmt.Errorf("failed to play stream: %w", err)
+	}
+	markStarted(group.CoordinatorId)
+
+	slog.InfoContext(ctx, "Playing stream", "room", room.Name, "title", name)
+	return nil
+}
+
+// createSession creates a playback session for cuore on the group, taking
+// over from the session currently playing.
+func (s *Sonos) createSession(ctx context.Context, groupId string) (string, error) {
+	sessionUrl := fmt.Sprintf("%s/groups/%s/playbackSession", baseURL, groupId)
+	payload := strings.NewReader(fmt.Sprintf(`{"appId": %q, "appContext": %q}`, appId, groupId))
+
+	body, err := s.sonosAPIRequest(ctx, sessionUrl, "POST", payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to create playback session: %w", err)
+	}
+
+	var session sessionResponse
+	if err := json.Unmarshal(body, &session); err != nil {
+		return "", fmt.Errorf("error decoding JSON: %w", err)
+	}
+	if session.SessionId == "" {
+		return "", fmt.Errorf("failed to create playback session: no session id")
+	}
+	return session.SessionId, nil
+}
@@ -0,0 +1,55 @@
+package sonos
+
+import (
+	"context"
+	"cuore/common"
+	"encoding/json"
+	"testing"
+)
+
+func TestPlayURLLoadsStream(t *testing.T) {
+	api := newFakeHousehold(t,
+		[]Group{{Id: "g1", Name: "Kitchen", CoordinatorId: "p1", PlayerIds: []string{"p1"}}},
+		[]Player{{Id: "p1", Name: "Kitchen"}},
+	)
+	api.responses["POST /groups/g1/playbackSession"] = `{"sessionId": "s1"}`
+
+	err := (&Sonos{}).HandleControl(context.Background(), common.ControlMessage{
+		Action: "play-url", Room: "Kitchen", URL: "https://radio.example/stream.mp3", Title: "Radio", Artist: "Host",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stream struct {
+		StreamUrl        string
+		PlayOnCompletion bool
+		StationMetadata  struct{ Name string }
+	}
+	if err := json.Unmarshal([]byte(api.bodies["POST /playbackSessions/s1/playbackSession/loadStreamUrl"]), &stream); err != nil {
+		t.Fatalf("expected the stream to be loaded into the session: %v", err)
+	}
+	if stream.StreamUrl != "https://radio.example/stream.mp3" || stream.StationMetadata.Name != "Radio - Host" {
+		t.Fatalf("unexpected stream %+v", stream)
+	}
+	if !api.sent("POST /groups/g1/playback/play") {
+		t.Fatal("expected the stream to be played")
+	}
+}
+
+func TestPlayURLRejectsOtherSchemes(t *testing.T) {
+	api := newFakeHousehold(t,
+		[]Group{{Id: "g1", Name: "Kitchen", CoordinatorId: "p1", PlayerIds: []string{"p1"}}},
+		[]Player{{Id: "p1", Name: "Kitchen"}},
+	)
+
+	for _, url := range []string{"file:///etc/passwd", "ftp://radio.example/stream.mp3", "https://", "radio.example/stream.mp3"} {
+		err := (&Sonos{}).HandleControl(context.Background(), common.ControlMessage{Action: "play-url", Room: "Kitchen", URL: url})
+		if err == nil || err.Error() != "play-url action requires an http or https URL" {
+			t.Errorf("%s: expected the URL to be rejected, got %v", url, err)
+		}
+	}
+	if api.sent("POST /groups/g1/playbackSession") {
+		t.Fatal("expected no playback session for a rejected URL")
+	}
+}
@@ -0,0 +1,227 @@
+package sonos
+
+import (
+	"context"
+	"cuore/config"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+)
+
+// preset is a named grouping of rooms, read from SONOS_GROUP_PRESETS_FILE:
+//
+//	{
+//	  "party": {"coordinator": "Living Room", "volume": 30, "continuePlayback": true},
+//	  "ground floor": {
+//	    "rooms": ["Living Room", "Kitchen", "Dining Room"],
+//	    "volumes": {"Kitchen": 20}
+//	  }
+//	}
+//
+// A preset without rooms groups all rooms.
+type preset struct {
+	Rooms            []string       `json:"rooms"`
+	Coordinator      string         `json:"coordinator"`
+	Volume           *int           `json:"volume"`
+	Volumes          map[string]int `json:"volumes"`
+	ContinuePlayback bool           `json:"continuePlayback"`
+}
+
+// loadPreset reads the preset from the presets file on every use, so edits
+// apply without a restart.
+func loadPreset(name string) (preset, error) {
+	var p preset
+
+	path := config.Get().SonosGroupPresetsFile
+	if path == "" {
+		return p, fmt.Errorf("no group presets configured")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return p, fmt.Errorf("failed to read group presets: %w", err)
+	}
+
+	var presets map[string]preset
+	if err := json.Unmarshal(data, &presets); err != nil {
+		return p, fmt.Errorf("failed to decode group presets: %w", err)
+	}
+
+	p, ok := presets[name]
+	if !ok {
+		return p, fmt.Errorf("group preset %s not found", name)
+	}
+	return p, nil
+}
+
+// presetPlayers resolves the rooms of a preset to player ids.
+func presetPlayers(p preset) ([]string, error) {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+
+	if len(p.Rooms) == 0 {
+		members := make([]string, 0, len(players))
+		for _, player := range players {
+			members = append(members, player.Id)
+		}
+		// sorted, so the first room as default coordinator is stable
+		sort.Strings(members)
+		return members, nil
+	}
+
+	members := make([]string, 0, len(p.Rooms))
+	for _, room := range p.Rooms {
+		player, ok := players[room]
+		if !ok {
+			return nil, fmt.Errorf("room %s of the preset not found", room)
+		}
+		members = append(members, player.Id)
+	}
+	return members, nil
+}
+
+// ApplyPreset groups the rooms of the named preset around a coordinator: the
+// given room, else the coordinator of the preset, else its first room. With
+// continuePlayback, a playing group among the rooms keeps its music and
+// leads the new group when the coordinator is not playing; otherwise the new
+// group is paused. volume overrides the uniform volume of the preset.
+func (s *Sonos) ApplyPreset(ctx context.Context, name string, coordinator string, volume *int) error {
+	p, err := loadPreset(name)
+	if err != nil {
+		return err
+	}
+
+	members, err := presetPlayers(p)
+	if err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		return fmt.Errorf("group preset %s has no rooms", name)
+	}
+
+	if coordinator == "" {
+		coordinator = p.Coordinator
+	}
+	lead := members[0]
+	if coordinator != "" {
+		if lead = playerId(coordinator); lead == "" {
+			return fmt.Errorf("room %s not found", coordinator)
+		}
+		if !slices.Contains(members, lead) {
+			return fmt.Errorf("room %s is not part of group preset %s", coordinator, name)
+		}
+	}
+
+	group, ok := groupOfPlayer(lead)
+	if !ok {
+		return fmt.Errorf("room %s is not in any group", coordinator)
+	}
+	if p.ContinuePlayback && !group.Playing() {
+		if playing, ok := playingGroupAmong(members); ok {
+			group = playing
+			lead = playing.CoordinatorId
+		}
+	}
+
+	// only the coordinator of a group keeps its music when regrouping
+	if group.CoordinatorId != lead {
+		if err := s.setGroupMembers(ctx, group.Id, group.Without(lead)); err != nil {
+			return err
+		}
+		if group, ok = groupOfPlayer(lead); !ok {
+			return fmt.Errorf("coordinator of the preset is not in any group")
+		}
+	}
+
+	if !sameMembers(group.PlayerIds, members) {
+		if err := s.setGroupMembers(ctx, group.Id, withLeadFirst(members, lead)); err != nil {
+			return err
+		}
+		if group, ok = groupOfPlayer(lead); !ok {
+			return fmt.Errorf("coordinator of the preset is not in any group")
+		}
+	}
+
+	if err := s.applyPresetVolumes(ctx, p, members, volume); err != nil {
+		return err
+	}
+
+	if !p.ContinuePlayback && group.Playing() {
+		url := fmt.Sprintf("%s/groups/%s/playback/pause", baseURL, group.Id)
+		if _, err := s.sonosAPIRequest(ctx, url, "POST", nil); err != nil {
+			return fmt.Errorf("failed to pause group: %w", err)
+		}
+	}
+
+	slog.InfoContext(ctx, "Applied group preset", "preset", name, "rooms", len(members))
+	return nil
+}
+
+func (s *Sonos) applyPresetVolumes(ctx context.Context, p preset, members []string, volume *int) error {
+	if volume == nil {
+		volume = p.Volume
+	}
+
+	for _, player := range members {
+		level := volume
+		for room, value := range p.Volumes {
+			if playerId(room) == player {
+				value := value
+				level = &value
+			}
+		}
+		if level == nil {
+			continue
+		}
+
+		if err := s.setPlayerVolume(ctx, player, playerVolume{Volume: *level}); err != nil {
+			return fmt.Errorf("failed to set volume of the preset: %w", err)
+		}
+	}
+	return nil
+}
+
+// UngroupAll splits every group into groups of a single player. Coordinators
+// keep playing, the other players stop.
+func (s *Sonos) UngroupAll(ctx context.Context) error {
+	stateMutex.RLock()
+	var grouped []Group
+	for _, group := range groups {
+		if len(group.PlayerIds) > 1 {
+			grouped = append(grouped, group)
+		}
+	}
+	stateMutex.RUnlock()
+
+	for _, group := range grouped {
+		if err := s.setGroupMembers(ctx, group.Id, []string{group.CoordinatorId}); err != nil {
+			return fmt.Errorf("failed to ungroup %s: %w", group.Name, err)
+		}
+	}
+
+	slog.InfoContext(ctx, "Ungrouped all rooms", "groups", len(grouped))
+	return nil
+}
+
+// playingGroupAmong returns a playing group led by one of members.
+func playingGroupAmong(members []string) (Group, bool) {
+	for _, member := range members {
+		if group, ok := groupOfPlayer(member); ok && group.Playing() && group.CoordinatorId == member {
+			return group, true
+		}
+	}
+	return Group{}, false
+}
+
+func withLeadFirst(members []string, lead string) []string {
+	ordered := []string{lead}
+	for _, member := range members {
+		if member != lead {
+			ordered = append(ordered, member)
+		}
+	}
+	return ordered
+}
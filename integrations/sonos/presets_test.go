@@ -0,0 +1,103 @@
+package sonos
+
+import (
+	"context"
+	"cuore/common"
+	"cuore/config"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const testPresets = `{
+	"ground floor": {"rooms": ["Kitchen", "Office"], "coordinator": "Office", "volume": 25},
+	"attic": {"rooms": ["Kitchen", "Attic"]}
+}`
+
+func newPresetHousehold(t *testing.T) *fakeHousehold {
+	path := filepath.Join(t.TempDir(), "presets.json")
+	if err := os.WriteFile(path, []byte(testPresets), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.Get().SonosGroupPresetsFile = path
+	t.Cleanup(func() { config.Get().SonosGroupPresetsFile = "" })
+
+	return newFakeHousehold(t,
+		[]Group{
+			{Id: "g1", Name: "Kitchen", CoordinatorId: "p1", PlayerIds: []string{"p1"}},
+			{Id: "g2", Name: "Office", CoordinatorId: "p2", PlayerIds: []string{"p2"}},
+			{Id: "g3", Name: "Bedroom", CoordinatorId: "p3", PlayerIds: []string{"p3"}},
+		},
+		[]Player{{Id: "p1", Name: "Kitchen"}, {Id: "p2", Name: "Office"}, {Id: "p3", Name: "Bedroom"}},
+	)
+}
+
+func TestApplyPresetGroupsAroundCoordinator(t *testing.T) {
+	api := newPresetHousehold(t)
+
+	err := (&Sonos{}).HandleControl(context.Background(), common.ControlMessage{Action: "group-preset", Name: "ground floor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if members := api.members("p1"); !slices.Equal(members, []string{"p2", "p1"}) {
+		t.Fatalf("expected Kitchen to join the group led by Office, got %v", members)
+	}
+	for _, player := range []string{"p1", "p2"} {
+		if body := api.bodies["POST /players/"+player+"/playerVolume"]; body != `{"volume": 25, "muted": false}` {
+			t.Errorf("expected the volume of the preset for %s, got %q", player, body)
+		}
+	}
+	if api.sent("POST /players/p3/playerVolume") {
+		t.Error("expected rooms outside the preset to keep their volume")
+	}
+}
+
+func TestApplyPresetValidatesRooms(t *testing.T) {
+	newPresetHousehold(t)
+
+	tests := []struct {
+		preset      string
+		coordinator string
+		err         string
+	}{
+		{"ground floor", "Bedroom", "room Bedroom is not part of group preset ground floor"},
+		{"ground floor", "Garage", "room Garage not found"},
+		{"attic", "", "room Attic of the preset not found"},
+		{"basement", "", "group preset basement not found"},
+	}
+
+	for _, test := range tests {
+		err := (&Sonos{}).HandleControl(context.Background(), common.ControlMessage{Action: "group-preset", Name: test.preset, Room: test.coordinator})
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s %s: expected error %q, got %v", test.preset, test.coordinator, test.err, err)
+		}
+	}
+}
+
+func TestUngroupAllKeepsCoordinators(t *testing.T) {
+	api := newFakeHousehold(t,
+		[]Group{
+			{Id: "g1", Name: "Kitchen + 2", CoordinatorId: "p2", PlaybackState: playbackStatePlaying, PlayerIds: []string{"p1", "p2", "p3"}},
+			{Id: "g4", Name: "Bedroom", CoordinatorId: "p4", PlayerIds: []string{"p4"}},
+		},
+		[]Player{{Id: "p1", Name: "Kitchen"}, {Id: "p2", Name: "Office"}, {Id: "p3", Name: "Bath"}, {Id: "p4", Name: "Bedroom"}},
+	)
+
+	if err := (&Sonos{}).HandleControl(context.Background(), common.ControlMessage{Action: "ungroup-all"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if body := api.bodies["POST /groups/g1/groups/setGroupMembers"]; body != `{"playerIds": ["p2"]}` {
+		t.Fatalf("expected the group to keep only its coordinator, got %q", body)
+	}
+	if api.sent("POST /groups/g4/groups/setGroupMembers") {
+		t.Fatal("expected single rooms to be left alone")
+	}
+	for _, player := range []string{"p1", "p2", "p3", "p4"} {
+		if members := api.members(player); len(members) != 1 {
+			t.Errorf("expected %s to be on its own, got %v", player, members)
+		}
+	}
+}
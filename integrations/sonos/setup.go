@@ -1,8 +1,11 @@
 package sonos
 
 import (
+	"context"
 	"cuore/common"
 	"cuore/config"
+	"fmt"
+	"strings"
 
 	"golang.org/x/oauth2"
 )
@@ -10,19 +13,17 @@ import (
 const (
 	authURL      = "https://api.sonos.com/login/v3/oauth"
 	tokenURL     = "https://api.sonos.com/login/v3/oauth/access"
-	redirectURL  = "http://localhost/integrations/sonos/auth"
+	redirectPath = "/integrations/sonos/auth"
 	providerName = "sonos"
-)
-
-var (
-	token *oauth2.Token
+	// whether the authorization server verifies a PKCE challenge
+	usePKCE = false
 )
 
 func getAuthConfig() *oauth2.Config {
 	return &oauth2.Config{
 		ClientID:     config.Get().SonosClientId,
 		ClientSecret: config.Get().SonosClientSecret,
-		RedirectURL:  redirectURL,
+		RedirectURL:  strings.TrimSuffix(config.Get().PublicURL, "/") + redirectPath,
 		Scopes:       []string{"playback-control-all"},
 		Endpoint: oauth2.Endpoint{
 			AuthURL:   authURL,
@@ -32,26 +33,43 @@ func getAuthConfig() *oauth2.Config {
 	}
 }
 
-func setToken(newToken *oauth2.Token) error {
-	err := common.SaveTokenForProvider(providerName, newToken)
-	if err != nil {
-		return err
-	}
-
-	token = newToken
+var tokens = common.NewTokenManager(providerName, func() string { return config.Get().SonosAccount }, getAuthConfig)
 
+func (s *Sonos) Start(ctx context.Context) error {
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	go tokens.Run(s.ctx)
+	go common.RetryUntilSuccess(s.ctx, "Sonos group discovery", config.Get().CommandTimeout, s.updateGroupsAndPlayers)
+	go s.pollNowPlaying(s.ctx)
 	return nil
 }
 
-func getToken() (*oauth2.Token, error) {
-	if token != nil {
-		return token, nil
+func (s *Sonos) Stop(ctx context.Context) error {
+	stopSleepTimers()
+	if s.cancel != nil {
+		s.cancel()
 	}
 
-	token, err := common.GetTokenForProvider(providerName)
-	if err != nil {
-		return nil, err
-	}
+	return nil
+}
+
+// Available reports whether the token is valid and the Sonos API reachable.
+func (s *Sonos) Available(ctx context.Context) error {
+	url := fmt.Sprintf("%s/households", baseURL)
+	_, err := s.sonosAPIRequest(ctx, url, "GET", nil)
+	return err
+}
+
+// Configured reports whether an OAuth client for the Control API is set up.
+func (s *Sonos) Configured() bool {
+	return config.Get().SonosClientId != ""
+}
+
+// Discovered reports whether groups and players have been loaded once.
+func (s *Sonos) Discovered() bool {
+	return s.discovered.Load()
+}
 
-	return token, nil
+// AuthState reports whether the Sonos account is authorized.
+func (s *Sonos) AuthState(ctx context.Context) (common.AuthState, error) {
+	return tokens.State()
 }
@@ -0,0 +1,246 @@
+package sonos
+
+import (
+	"context"
+	"cuore/common"
+	"cuore/config"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// fadeStepInterval is the shortest time between two volume steps
+	fadeStepInterval = 500 * time.Millisecond
+	// restoreTimeout bounds restoring the volume after a cancelled fade
+	restoreTimeout = 5 * time.Second
+)
+
+// sleepTimer pauses the group of a room at deadline. The timers are owned by
+// cuore rather than the players, so they behave the same for every mode.
+type sleepTimer struct {
+	deadline time.Time
+	cancel   context.CancelFunc
+}
+
+var sleepTimers = struct {
+	mutex  sync.Mutex
+	timers map[string]*sleepTimer // room -> timer
+}{timers: map[string]*sleepTimer{}}
+
+// Sleep pauses the room after the given number of minutes, fading out over
+// the last SONOS_SLEEP_FADE. It replaces an earlier timer of the room.
+func (s *Sonos) Sleep(ctx context.Context, room Room, minutes int) error {
+	if minutes <= 0 {
+		return fmt.Errorf("sleep timer requires a positive number of minutes")
+	}
+	if playerId(room.Name) == "" {
+		return fmt.Errorf("room %s not found", room.Name)
+	}
+	if s.ctx == nil {
+		return fmt.Errorf("sonos integration is not started")
+	}
+
+	timerCtx, cancel := context.WithCancel(s.ctx)
+	timer := &sleepTimer{
+		deadline: time.Now().Add(time.Duration(minutes) * time.Minute),
+		cancel:   cancel,
+	}
+
+	sleepTimers.mutex.Lock()
+	if previous, ok := sleepTimers.timers[room.Name]; ok {
+		previous.cancel()
+	}
+	sleepTimers.timers[room.Name] = timer
+	sleepTimers.mutex.Unlock()
+
+	go s.runSleepTimer(timerCtx, room, timer)
+
+	slog.InfoContext(ctx, "Started sleep timer", "room", room.Name, "minutes", minutes)
+	return nil
+}
+
+// CancelSleep stops the sleep timer of the room, if any.
+func (s *Sonos) CancelSleep(ctx context.Context, room Room) error {
+	sleepTimers.mutex.Lock()
+	timer, ok := sleepTimers.timers[room.Name]
+	delete(sleepTimers.timers, room.Name)
+	sleepTimers.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no sleep timer running for room %s", room.Name)
+	}
+	timer.cancel()
+
+	slog.InfoContext(ctx, "Cancelled sleep timer", "room", room.Name)
+	return nil
+}
+
+// sleepRemaining returns the time left on the sleep timer of the room.
+func sleepRemaining(room string) (time.Duration, bool) {
+	sleepTimers.mutex.Lock()
+	defer sleepTimers.mutex.Unlock()
+
+	timer, ok := sleepTimers.timers[room]
+	if !ok {
+		return 0, false
+	}
+	return max(time.Until(timer.deadline), 0), true
+}
+
+// stopSleepTimers cancels all sleep timers without pausing their rooms.
+func stopSleepTimers() {
+	sleepTimers.mutex.Lock()
+	defer sleepTimers.mutex.Unlock()
+
+	for room, timer := range sleepTimers.timers {
+		timer.cancel()
+		delete(sleepTimers.timers, room)
+	}
+}
+
+func (s *Sonos) runSleepTimer(ctx context.Context, room Room, timer *sleepTimer) {
+	fade := min(config.Get().SonosSleepFade, time.Until(timer.deadline))
+
+	wait := time.NewTimer(time.Until(timer.deadline) - fade)
+	defer wait.Stop()
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-wait.C:
+	}
+
+	ctx = common.WithTraceId(ctx)
+	if err := s.sleepNow(ctx, room, fade); err != nil {
+		slog.ErrorContext(ctx, "Error stopping music for sleep timer", "room", room.Name, "error", err)
+	}
+
+	sleepTimers.mutex.Lock()
+	if sleepTimers.timers[room.Name] == timer {
+		delete(sleepTimers.timers, room.Name)
+	}
+	sleepTimers.mutex.Unlock()
+}
+
+// sleepNow fades out the group of the room, pauses it and restores the
+// volume for the next time it plays.
+func (s *Sonos) sleepNow(ctx context.Context, room Room, fade time.Duration) error {
+	requestCtx, cancel := context.WithTimeout(ctx, config.Get().CommandTimeout)
+	defer cancel()
+
+	if err := s.updateGroupsAndPlayers(requestCtx); err != nil {
+		return err
+	}
+
+	group, ok := groupOfPlayer(playerId(room.Name))
+	if !ok {
+		return fmt.Errorf("room %s is not in any group", room.Name)
+	}
+	if !group.Playing() {
+		return nil
+	}
+
+	volume, err := s.getGroupVolume(requestCtx, group.Id)
+	if err != nil {
+		return fmt.Errorf("failed to read volume: %w", err)
+	}
+
+	// the volume is restored however the fade ends, also when the timer is
+	// cancelled or cuore shuts down halfway
+	defer func() {
+		restoreCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), restoreTimeout)
+		defer cancel()
+		if err := s.setGroupVolume(restoreCtx, group.Id, volume); err != nil {
+			slog.ErrorContext(ctx, "Error restoring volume after sleep timer", "room", room.Name, "error", err)
+		}
+	}()
+
+	if err := s.fadeOut(ctx, group.Id, volume, fade); err != nil {
+		return err
+	}
+
+	requestCtx, cancel = context.WithTimeout(ctx, config.Get().CommandTimeout)
+	defer cancel()
+
+	url := fmt.Sprintf("%s/groups/%s/playback/pause", baseURL, group.Id)
+	if _, err := s.sonosAPIRequest(requestCtx, url, "POST", nil); err != nil {
+		return fmt.Errorf("failed to pause: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Sleep timer stopped music", "room", room.Name)
+	return nil
+}
+
+// fadeOut lowers the group volume to zero in steps of at least
+// fadeStepInterval.
+func (s *Sonos) fadeOut(ctx context.Context, groupId string, volume int, fade time.Duration) error {
+	steps := min(int(fade/fadeStepInterval), volume)
+	if steps <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(fade / time.Duration(steps))
+	defer ticker.Stop()
+
+	for step := 1; step <= steps; step++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		requestCtx, cancel := context.WithTimeout(ctx, config.Get().CommandTimeout)
+		err := s.setGroupVolume(requestCtx, groupId, volume*(steps-step)/steps)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to fade out: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *Sonos) getGroupVolume(ctx context.Context, groupId string) (int, error) {
+	url := fmt.Sprintf("%s/groups/%s/groupVolume", baseURL, groupId)
+	body, err := s.sonosAPIRequest(ctx, url, "GET", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var response struct {
+		Volume int `json:"volume"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return 0, fmt.Errorf("error decoding JSON: %w", err)
+	}
+	return response.Volume, nil
+}
+
+func (s *Sonos) setGroupVolume(ctx context.Context, groupId string, volume int) error {
+	url := fmt.Sprintf("%s/groups/%s/groupVolume", baseURL, groupId)
+	payload := strings.NewReader(fmt.Sprintf(`{"volume": %d}`, volume))
+
+	_, err := s.sonosAPIRequest(ctx, url, "POST", payload)
+	return err
+}
+
+// RoomState reports whether the group of the room is playing and the time
+// left on its sleep timer.
+func (s *Sonos) RoomState(room string) (any, bool) {
+	player := playerId(room)
+	if player == "" {
+		return nil, false
+	}
+
+	var state State
+	if group, ok := groupOfPlayer(player); ok {
+		state.Playing = group.Playing()
+	}
+	if remaining, ok := sleepRemaining(room); ok {
+		state.SleepRemaining = int(remaining.Round(time.Second).Seconds())
+	}
+	return state, true
+}
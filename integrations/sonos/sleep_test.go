@@ -0,0 +1,74 @@
+package sonos
+
+import (
+	"context"
+	"cuore/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestCancelledFadeRestoresVolume(t *testing.T) {
+	config.Get().CredentialStore = "file"
+	config.Get().EncryptionFilePath = t.TempDir()
+	config.Get().EncryptionKey = "example key 1234"
+	config.Get().SonosHouseholdId = "household"
+	config.Get().CommandTimeout = time.Second
+	config.Get().VendorRequestTimeout = time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mutex sync.Mutex
+	var volumes []int
+	paused := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch {
+		case r.URL.Path == "/households/household/groups":
+			json.NewEncoder(w).Encode(GroupsResponse{
+				Groups:  []Group{{Id: "g1", Name: "Kids", CoordinatorId: "p1", PlaybackState: playbackStatePlaying, PlayerIds: []string{"p1"}}},
+				Players: []Player{{Id: "p1", Name: "Kids"}},
+			})
+		case r.URL.Path == "/groups/g1/groupVolume" && r.Method == "GET":
+			w.Write([]byte(`{"volume": 20}`))
+		case r.URL.Path == "/groups/g1/groupVolume":
+			w.Write([]byte("{}"))
+			var body struct{ Volume int }
+			json.NewDecoder(r.Body).Decode(&body)
+			volumes = append(volumes, body.Volume)
+			// sleep-cancel right after the first step of the fade
+			cancel()
+		case r.URL.Path == "/groups/g1/playback/pause":
+			paused = true
+		}
+	}))
+	defer server.Close()
+
+	defer func(url string) { baseURL = url }(baseURL)
+	baseURL = server.URL
+	if err := tokens.SetToken(&oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Sonos{}
+	if err := s.sleepNow(ctx, Room{Name: "Kids"}, 2*time.Second); err == nil {
+		t.Fatal("expected the cancelled fade to fail")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(volumes) < 2 || volumes[0] >= 20 || volumes[len(volumes)-1] != 20 {
+		t.Fatalf("expected the volume to be restored to 20 after fading, got %v", volumes)
+	}
+	if paused {
+		t.Fatal("expected a cancelled sleep timer not to pause")
+	}
+}
@@ -0,0 +1,261 @@
+package sonos
+
+import (
+	"context"
+	"cuore/common"
+	"cuore/config"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// musicTarget is the target of the messages handled by Sonos.
+const musicTarget = "music"
+
+// snapshot is the state of the group a room belongs to.
+type snapshot struct {
+	CoordinatorId string                  `json:"coordinatorId"`
+	PlayerIds     []string                `json:"playerIds"`
+	Playing       bool                    `json:"playing"`
+	Volumes       map[string]playerVolume `json:"volumes"`
+	Container     *container              `json:"container,omitempty"`
+}
+
+type playerVolume struct {
+	Volume int  `json:"volume"`
+	Muted  bool `json:"muted"`
+}
+
+type container struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Id   *struct {
+		ServiceId string `json:"serviceId"`
+		ObjectId  string `json:"objectId"`
+		AccountId string `json:"accountId"`
+	} `json:"id"`
+	Service  *service `json:"service,omitempty"`
+	ImageUrl string   `json:"imageUrl,omitempty"`
+}
+
+type service struct {
+	Name string `json:"name"`
+}
+
+type playbackMetadata struct {
+	Container   *container `json:"container"`
+	CurrentItem *struct {
+		Track *track `json:"track"`
+	} `json:"currentItem"`
+}
+
+type track struct {
+	Name   string `json:"name"`
+	Artist *struct {
+		Name string `json:"name"`
+	} `json:"artist"`
+	Album *struct {
+		Name string `json:"name"`
+	} `json:"album"`
+	ImageUrl       string   `json:"imageUrl"`
+	DurationMillis int      `json:"durationMillis"`
+	Service        *service `json:"service"`
+}
+
+type favoritesResponse struct {
+	Items []struct {
+		Id       string `json:"id"`
+		Name     string `json:"name"`
+		Resource struct {
+			Id *struct {
+				ServiceId string `json:"serviceId"`
+				ObjectId  string `json:"objectId"`
+			} `json:"id"`
+		} `json:"resource"`
+	} `json:"items"`
+}
+
+// Snapshot records group membership, playback state, volumes and the
+// current container of the group the room belongs to.
+func (s *Sonos) Snapshot(ctx context.Context, room Room, name string) error {
+	group, ok := groupOfPlayer(playerId(room.Name))
+	if !ok {
+		return fmt.Errorf("room %s is not in any group", room.Name)
+	}
+
+	snap := snapshot{
+		CoordinatorId: group.CoordinatorId,
+		PlayerIds:     group.PlayerIds,
+		Playing:       group.Playing(),
+		Volumes:       map[string]playerVolume{},
+	}
+
+	for _, player := range group.PlayerIds {
+		volume, err := s.getPlayerVolume(ctx, player)
+		if err != nil {
+			return fmt.Errorf("failed to snapshot volume: %w", err)
+		}
+		snap.Volumes[player] = volume
+	}
+
+	metadata, err := s.getPlaybackMetadata(ctx, group.Id)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot playback metadata: %w", err)
+	}
+	snap.Container = metadata.Container
+
+	if err := common.SaveSnapshot(musicTarget, room.Name, name, snap); err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "Saved snapshot", "room", room.Name, "snapshot", name)
+	return nil
+}
+
+// Restore regroups the players of a snapshot and restores their container,
+// volumes and playback state.
+func (s *Sonos) Restore(ctx context.Context, room Room, name string) error {
+	var snap snapshot
+	if err := common.LoadSnapshot(musicTarget, room.Name, name, &snap); err != nil {
+		return err
+	}
+
+	groupId := groupForPlayer(snap.CoordinatorId)
+	if groupId == "" {
+		return fmt.Errorf("coordinator of the snapshot is not in any group")
+	}
+	if !sameMembers(groupMembers(groupId), snap.PlayerIds) {
+		if err := s.setGroupMembers(ctx, groupId, snap.PlayerIds); err != nil {
+			return fmt.Errorf("failed to restore group: %w", err)
+		}
+		groupId = groupForPlayer(snap.CoordinatorId)
+	}
+
+	if snap.Container != nil {
+		if err := s.restoreContainer(ctx, groupId, snap.Container); err != nil {
+			return err
+		}
+	}
+
+	for player, volume := range snap.Volumes {
+		if err := s.setPlayerVolume(ctx, player, volume); err != nil {
+			return fmt.Errorf("failed to restore volume: %w", err)
+		}
+	}
+
+	action := "pause"
+	if snap.Playing {
+		action = "play"
+	}
+	url := fmt.Sprintf("%s/groups/%s/playback/%s", baseURL, groupId, action)
+	if _, err := s.sonosAPIRequest(ctx, url, "POST", nil); err != nil {
+		return fmt.Errorf("failed to restore playback: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Restored snapshot", "room", room.Name, "snapshot", name)
+	return nil
+}
+
+// restoreContainer loads the favorite matching the container of a snapshot,
+// unless the group still plays it. Containers that are not a favorite cannot
+// be loaded through the Control API.
+func (s *Sonos) restoreContainer(ctx context.Context, groupId string, saved *container) error {
+	metadata, err := s.getPlaybackMetadata(ctx, groupId)
+	if err != nil {
+		return fmt.Errorf("failed to read playback metadata: %w", err)
+	}
+	if sameContainer(metadata.Container, saved) || saved.Id == nil {
+		return nil
+	}
+
+	url := fmt.Sprintf("%s/households/%s/favorites", baseURL, config.Get().SonosHouseholdId)
+	body, err := s.sonosAPIRequest(ctx, url, "GET", nil)
+	if err != nil {
+		return fmt.Errorf("failed to list favorites: %w", err)
+	}
+
+	var favorites favoritesResponse
+	if err := json.Unmarshal(body, &favorites); err != nil {
+		return fmt.Errorf("error decoding JSON: %w", err)
+	}
+
+	for _, favorite := range favorites.Items {
+		id := favorite.Resource.Id
+		if id == nil || id.ServiceId != saved.Id.ServiceId || id.ObjectId != saved.Id.ObjectId {
+			continue
+		}
+
+		url := fmt.Sprintf("%s/groups/%s/favorites", baseURL, groupId)
+		payload := strings.NewReader(fmt.Sprintf(`{"favoriteId": %q}`, favorite.Id))
+		if _, err := s.sonosAPIRequest(ctx, url, "POST", payload); err != nil {
+			return fmt.Errorf("failed to load favorite %s: %w", favorite.Name, err)
+		}
+		return nil
+	}
+
+	slog.WarnContext(ctx, "Container of the snapshot is not a favorite and cannot be restored", "container", saved.Name)
+	return nil
+}
+
+func (s *Sonos) getPlayerVolume(ctx context.Context, player string) (playerVolume, error) {
+	var volume playerVolume
+
+	url := fmt.Sprintf("%s/players/%s/playerVolume", baseURL, player)
+	body, err := s.sonosAPIRequest(ctx, url, "GET", nil)
+	if err != nil {
+		return volume, err
+	}
+
+	if err := json.Unmarshal(body, &volume); err != nil {
+		return volume, fmt.Errorf("error decoding JSON: %w", err)
+	}
+	return volume, nil
+}
+
+func (s *Sonos) setPlayerVolume(ctx context.Context, player string, volume playerVolume) error {
+	url := fmt.Sprintf("%s/players/%s/playerVolume", baseURL, player)
+	payload := strings.NewReader(fmt.Sprintf(`{"volume": %d, "muted": %t}`, volume.Volume, volume.Muted))
+
+	_, err := s.sonosAPIRequest(ctx, url, "POST", payload)
+	return err
+}
+
+func (s *Sonos) getPlaybackMetadata(ctx context.Context, groupId string) (playbackMetadata, error) {
+	var metadata playbackMetadata
+
+	url := fmt.Sprintf("%s/groups/%s/playbackMetadata", baseURL, groupId)
+	body, err := s.sonosAPIRequest(ctx, url, "GET", nil)
+	if err != nil {
+		return metadata, err
+	}
+
+	if err := json.Unmarshal(body, &metadata); err != nil {
+		return metadata, fmt.Errorf("error decoding JSON: %w", err)
+	}
+	return metadata, nil
+}
+
+func sameContainer(a *container, b *container) bool {
+	if a == nil || b == nil || a.Id == nil || b.Id == nil {
+		return false
+	}
+	return a.Id.ServiceId == b.Id.ServiceId && a.Id.ObjectId == b.Id.ObjectId
+}
+
+func sameMembers(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	members := map[string]bool{}
+	for _, member := range a {
+		members[member] = true
+	}
+	for _, member := range b {
+		if !members[member] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,8 +1,19 @@
 package sonos
 
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
 type Sonos struct {
 	Rooms          []Room
 	ControlPlayers bool
+
+	ctx        context.Context
+	cancel     context.CancelFunc
+	roomsMutex sync.Mutex
+	discovered atomic.Bool
 }
 
 type Room struct {
@@ -12,6 +23,8 @@ type Room struct {
 }
 
 type State struct {
-	Value   int  `json:"value"`
+	Value   int  `json:"value,omitempty"`
 	Playing bool `json:"isPlaying"`
+	// SleepRemaining is the number of seconds left on the sleep timer
+	SleepRemaining int `json:"sleepRemaining,omitempty"`
 }
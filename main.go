@@ -1,154 +1,328 @@
 package main
 
 import (
+	"context"
 	"cuore/common"
 	"cuore/config"
+	"cuore/integrations"
 	"cuore/integrations/hue"
 	"cuore/integrations/sonos"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
 	"sync"
+	"syscall"
 	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 var Sonos *sonos.Sonos = &sonos.Sonos{ControlPlayers: true}
 var Hue *hue.Hue = &hue.Hue{}
 
+// targets maps the target of incoming messages to the integration handling them.
+var targets = map[string]integrations.Integration{
+	"music": Sonos,
+	"light": Hue,
+}
+
+// dispatcher queues commands per target and room.
+var dispatcher *common.Dispatcher
+
+// coalescer and debouncer thin out bursts of control messages before they
+// reach the dispatcher.
+var coalescer *common.Coalescer
+var debouncer *common.Debouncer
+
 func init() {
 	config.LoadEnvs()
+	common.SetupLogging(config.Get().LogLevel, config.Get().LogFormat)
+	dispatcher = common.NewDispatcher(
+		config.Get().DispatchWorkers,
+		config.Get().DispatchQueueDepth,
+		config.Get().CommandTimeout,
+	)
+	coalescer = common.NewCoalescer(config.Get().CoalesceWindow, config.Get().CoalesceActions)
+	debouncer = common.NewDebouncer(config.Get().DebounceInterval, config.Get().DebounceActions)
 }
 
 func main() {
+	switch config.Get().EncryptionKey {
+	case "":
+		fatal("ENCRYPTION_KEY must be set to encrypt stored credentials")
+	case config.LegacyEncryptionKey:
+		slog.Warn("ENCRYPTION_KEY is the publicly known former default, stored credentials are not protected; set a new key with rotate-key")
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "rotate-key" {
+		rotateKey()
+		return
+	}
+
+	if _, err := common.OpenCredentialStore(); err != nil {
+		fatal("Error opening credential store", "error", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if store, err := common.OpenHistoryStore(config.Get().HistoryDBPath); err != nil {
+		slog.Error("Error opening history store, messages will not be recorded", "error", err)
+	} else {
+		history = store
+		go history.RunRetention(ctx, config.Get().HistoryRetention)
+	}
+
+	for target, integration := range targets {
+		if err := integration.Start(ctx); err != nil {
+			slog.Error("Error starting integration", "target", target, "error", err)
+		}
+	}
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
-	shutdownChan := make(chan struct{})
-	defer close(shutdownChan)
-
-	go apiRouter(&wg, shutdownChan)
-	go mqttBroker(&wg, shutdownChan)
+	go apiRouter(ctx, &wg)
+	go mqttBroker(ctx, &wg)
 
 	wg.Wait()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Get().ShutdownTimeout)
+	defer cancel()
+
+	coalescer.Stop()
+	if err := dispatcher.Wait(shutdownCtx); err != nil {
+		slog.Error("Error waiting for queued commands", "error", err)
+	}
+
+	for target, integration := range targets {
+		if err := integration.Stop(shutdownCtx); err != nil {
+			slog.Error("Error stopping integration", "target", target, "error", err)
+		}
+	}
+
+	if err := common.CloseCredentialStore(); err != nil {
+		slog.Error("Error closing credential store", "error", err)
+	}
+
+	if history != nil {
+		if err := history.Close(); err != nil {
+			slog.Error("Error closing history store", "error", err)
+		}
+	}
+
+	slog.Info("Shutdown complete")
+}
+
+// rotateKey re-encrypts all stored credentials with NEW_ENCRYPTION_KEY. Afterwards
+// ENCRYPTION_KEY has to be set to the new key.
+func rotateKey() {
+	newKey := config.Get().NewEncryptionKey
+	if newKey == "" {
+		fatal("NEW_ENCRYPTION_KEY must be set to rotate the encryption key")
+	}
+
+	store, err := common.OpenCredentialStore()
+	if err != nil {
+		fatal("Error opening credential store", "error", err)
+	}
+	defer store.Close()
+
+	if err := store.Rotate(newKey); err != nil {
+		fatal("Error rotating encryption key", "error", err)
+	}
+
+	slog.Info("Encryption key rotated, set ENCRYPTION_KEY to the new key")
 }
 
-func apiRouter(wg *sync.WaitGroup, shutdownChan <-chan struct{}) {
+// fatal logs an error and exits, as slog has no equivalent of log.Fatal.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func apiRouter(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
-	r := gin.Default()
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", 80),
+		Handler: newRouter(),
+	}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Error running API router", "error", err)
+		}
+	}()
+
+	<-ctx.Done()
+	slog.Info("Shutting down API router")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Get().ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error shutting down API router", "error", err)
+	}
+}
+
+// newRouter sets up the HTTP API. Routes that change or reveal credentials
+// and history require the admin credentials.
+func newRouter() *gin.Engine {
+	r := gin.New()
+	// handlers pass the gin context on, so it has to carry the trace id
+	r.ContextWithFallback = true
+	r.Use(common.RequestLogger(), gin.Recovery())
 
 	r.NoRoute(func(c *gin.Context) {
 		c.JSON(404, gin.H{"code": 404, "message": "Page not found"})
 	})
 
-	var sonosRoutes *gin.RouterGroup = r.Group("/integrations/sonos")
-	var hueRoutes *gin.RouterGroup = r.Group("/integrations/hue")
+	var sonosRoutes *gin.RouterGroup = r.Group("/integrations/sonos", common.AdminAuth())
+	var hueRoutes *gin.RouterGroup = r.Group("/integrations/hue", common.AdminAuth())
 	Sonos.AuthorizationHandlers(sonosRoutes)
 	Hue.AuthorizationHandlers(hueRoutes)
 
-	err := http.ListenAndServe(fmt.Sprintf(":%d", 80), r)
-	if err != nil {
-		log.Print(err)
-	}
+	r.GET("/status", statusHandler)
+	r.GET("/healthz", healthHandler)
+	r.GET("/readyz", readyHandler)
+	// public, so wall tablets can load images without credentials; ids are
+	// unguessable hashes handed out with the now-playing state
+	r.GET("/album-art/:id", common.AlbumArtHandler)
+
+	var apiRoutes *gin.RouterGroup = r.Group("/api/v1", common.AdminAuth())
+	apiRoutes.GET("/history", historyHandler)
+	apiRoutes.GET("/rooms/:room/state", roomStateHandler)
+	apiRoutes.GET("/rooms/:room/now-playing", nowPlayingHandler)
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
-	<-shutdownChan
-	log.Print("Shutting down API router")
+	return r
 }
 
-func handleControlMessage(msg common.ControlMessage) {
-	var err error
-	switch msg.Target {
-	case "music":
-		err = Sonos.HandleControl(msg)
-	case "light":
-		err = Hue.HandleControl(msg)
-	default:
-		log.Printf("Unknown target type: %s", msg.Target)
-		return
+// statusHandler reports the authorization state of every integration.
+func statusHandler(c *gin.Context) {
+	status := gin.H{}
+	for target, integration := range targets {
+		state, err := integration.AuthState(c)
+		entry := gin.H{"auth": state}
+		if err != nil {
+			entry["error"] = err.Error()
+		}
+		status[target] = entry
 	}
 
-	if err != nil {
-		log.Printf("Error handling control message: %v", err)
-	}
+	c.JSON(http.StatusOK, status)
 }
 
-func handleSetupMessage(msg common.SetupMessage) {
-	var err error
-	switch msg.Target {
-	case "music":
-		err = Sonos.HandleSetup(msg)
-	case "light":
-		err = Hue.HandleSetup(msg)
-	default:
-		log.Printf("Unknown target type: %s", msg.Target)
+// handleControlMessage queues msg behind earlier commands for the same room.
+// source is recorded in the history, e.g. the MQTT topic msg came from.
+// Repeated toggles are debounced, and only the latest value of continuous
+// actions such as volume is kept within the coalesce window.
+func handleControlMessage(ctx context.Context, source string, msg common.ControlMessage) {
+	received := time.Now()
+	record := controlRecord(source, msg)
+
+	integration, ok := targets[msg.Target]
+	if !ok {
+		slog.WarnContext(ctx, "Unknown target type", "target", msg.Target)
+		recordHistory(ctx, record, received, common.HistoryOutcomeRejected, fmt.Errorf("unknown target %s", msg.Target))
 		return
 	}
 
-	if err != nil {
-		log.Printf("Error handling setup message: %v", err)
+	key := msg.Target + "/" + msg.Room
+	if multiRoom, ok := integration.(integrations.MultiRoomActions); ok && multiRoom.MultiRoom(msg.Action) {
+		key = msg.Target + "/*"
 	}
-}
-
-func controlMessageHandler(client mqtt.Client, msg mqtt.Message) {
-	var controlMsg common.ControlMessage
-	if err := json.Unmarshal(msg.Payload(), &controlMsg); err != nil {
-		log.Printf("Error decoding control message: %v", err)
+	action := actionLabel(integration, msg.Action)
+	if !debouncer.Allow(key+"/"+msg.Action, msg.Action) {
+		common.CommandsTotal.WithLabelValues(msg.Target, action, "debounced").Inc()
+		slog.DebugContext(ctx, "Dropping repeated command", "action", msg.Action, "room", msg.Room)
+		recordHistory(ctx, record, received, common.HistoryOutcomeDebounced, nil)
 		return
 	}
-	handleControlMessage(controlMsg)
-}
 
-func setupMessageHandler(client mqtt.Client, msg mqtt.Message) {
-	var setupMsg common.SetupMessage
-	if err := json.Unmarshal(msg.Payload(), &setupMsg); err != nil {
-		log.Printf("Error decoding setup message: %v", err)
+	// discrete actions carry no value and are never coalesced
+	if msg.Value != nil && coalescer.Coalesces(msg.Action) {
+		coalescer.Submit(key+"/"+msg.Action,
+			func() { submitControlMessage(ctx, integration, key, msg, record, received) },
+			func() { recordHistory(ctx, record, received, common.HistoryOutcomeCoalesced, nil) },
+		)
 		return
 	}
-	handleSetupMessage(setupMsg)
+
+	coalescer.Flush(key + "/")
+	submitControlMessage(ctx, integration, key, msg, record, received)
 }
 
-func mqttBroker(wg *sync.WaitGroup, shutdownChan <-chan struct{}) {
-	defer wg.Done()
-	opts := mqtt.NewClientOptions().AddBroker(config.Get().MQTTServer)
-	// TODO: move to Config
-	opts.SetClientID("cuore")
-
-	c := mqtt.NewClient(opts)
-
-	//we are going to try connecting for max 10 times to the server if the connection fails.
-	for i := 0; i < 10; i++ {
-		if token := c.Connect(); token.Wait() && token.Error() == nil {
-			break
-		} else {
-			log.Print(token.Error())
-			time.Sleep(1 * time.Second)
-		}
+// actionLabel returns action for use as a metric label, or "unknown" when
+// the integration does not handle it, so arbitrary payloads cannot create new
+// series.
+func actionLabel(integration integrations.Integration, action string) string {
+	if slices.Contains(integration.Actions(), action) {
+		return action
 	}
+	return "unknown"
+}
 
-	if token := c.Subscribe("control", 0, controlMessageHandler); token.Wait() && token.Error() != nil {
-		log.Print(token.Error())
-		os.Exit(1)
-	}
-	if token := c.Subscribe("setup", 0, setupMessageHandler); token.Wait() && token.Error() != nil {
-		log.Print(token.Error())
-		os.Exit(1)
+func submitControlMessage(ctx context.Context, integration integrations.Integration, key string, msg common.ControlMessage, record common.HistoryRecord, received time.Time) {
+	action := actionLabel(integration, msg.Action)
+	err := dispatcher.Submit(ctx, key,
+		func(ctx context.Context) error {
+			timer := prometheus.NewTimer(common.CommandDuration.WithLabelValues(msg.Target, action))
+			defer timer.ObserveDuration()
+			return integration.HandleControl(ctx, msg)
+		},
+		func(err error) {
+			if err != nil {
+				common.CommandsTotal.WithLabelValues(msg.Target, action, "failure").Inc()
+				slog.ErrorContext(ctx, "Error handling control message", "target", msg.Target, "action", msg.Action, "room", msg.Room, "error", err)
+				recordHistory(ctx, record, received, common.HistoryOutcomeFailure, err)
+				return
+			}
+			common.CommandsTotal.WithLabelValues(msg.Target, action, "success").Inc()
+			recordHistory(ctx, record, received, common.HistoryOutcomeSuccess, nil)
+		},
+	)
+	if err != nil {
+		common.CommandsTotal.WithLabelValues(msg.Target, action, "rejected").Inc()
+		slog.ErrorContext(ctx, "Error queuing control message", "target", msg.Target, "room", msg.Room, "error", err)
+		recordHistory(ctx, record, received, common.HistoryOutcomeRejected, err)
 	}
+}
 
-	<-shutdownChan
+// handleSetupMessage queues msg behind earlier setup commands for the target.
+func handleSetupMessage(ctx context.Context, source string, msg common.SetupMessage) {
+	received := time.Now()
+	record := setupRecord(source, msg)
 
-	log.Print("Shutting down MQTT broker")
-	if token := c.Unsubscribe("control"); token.Wait() && token.Error() != nil {
-		log.Print(token.Error())
-		os.Exit(1)
-	}
-	if token := c.Unsubscribe("setup"); token.Wait() && token.Error() != nil {
-		log.Print(token.Error())
-		os.Exit(1)
+	integration, ok := targets[msg.Target]
+	if !ok {
+		slog.WarnContext(ctx, "Unknown target type", "target", msg.Target)
+		recordHistory(ctx, record, received, common.HistoryOutcomeRejected, fmt.Errorf("unknown target %s", msg.Target))
+		return
 	}
 
-	c.Disconnect(250)
+	err := dispatcher.Submit(ctx, msg.Target+"/setup",
+		func(ctx context.Context) error {
+			return integration.HandleSetup(ctx, msg)
+		},
+		func(err error) {
+			if err != nil {
+				slog.ErrorContext(ctx, "Error handling setup message", "target", msg.Target, "command", msg.Command, "error", err)
+				recordHistory(ctx, record, received, common.HistoryOutcomeFailure, err)
+				return
+			}
+			recordHistory(ctx, record, received, common.HistoryOutcomeSuccess, nil)
+		},
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error queuing setup message", "target", msg.Target, "error", err)
+		recordHistory(ctx, record, received, common.HistoryOutcomeRejected, err)
+	}
 }
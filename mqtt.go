@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"cuore/common"
+	"cuore/config"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	controlTopic = "control"
+	setupTopic   = "setup"
+
+	statusOnline  = "online"
+	statusOffline = "offline"
+)
+
+// topic prepends the configured topic prefix, so several homes can share
+// one broker.
+func topic(name string) string {
+	prefix := strings.TrimSuffix(config.Get().MQTTTopicPrefix, "/")
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+func statusTopic() string {
+	return topic(config.Get().MQTTStatusTopic)
+}
+
+func integrationStatusTopic(target string) string {
+	return topic(config.Get().MQTTStatusTopic + "/" + target)
+}
+
+func publishRetained(c mqtt.Client, name string, payload string) {
+	if token := c.Publish(name, 1, true, payload); token.Wait() && token.Error() != nil {
+		slog.Error("Error publishing to MQTT", "topic", name, "error", token.Error())
+	}
+}
+
+// publishAvailability periodically publishes whether each integration is
+// authorized and its vendor API reachable, as well as its authorization state.
+func publishAvailability(ctx context.Context, c mqtt.Client) {
+	ticker := time.NewTicker(config.Get().AvailabilityInterval)
+	defer ticker.Stop()
+
+	available := map[string]bool{}
+	for {
+		for target, integration := range targets {
+			err := checkAvailable(ctx, target, integration)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if previous, seen := available[target]; !seen || previous != (err == nil) {
+				if err != nil {
+					slog.Warn("Integration is unavailable", "target", target, "error", err)
+				} else {
+					slog.Info("Integration is available", "target", target)
+				}
+			}
+			available[target] = err == nil
+
+			status := statusOnline
+			if err != nil {
+				status = statusOffline
+			}
+			publishRetained(c, integrationStatusTopic(target), status)
+
+			authState, _ := integration.AuthState(ctx)
+			publishRetained(c, integrationStatusTopic(target)+"/auth", string(authState))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func controlMessageHandler(ctx context.Context) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		ctx := common.WithTraceId(ctx)
+
+		var controlMsg common.ControlMessage
+		if err := json.Unmarshal(msg.Payload(), &controlMsg); err != nil {
+			common.MQTTMessagesReceived.WithLabelValues(msg.Topic(), "invalid").Inc()
+			slog.WarnContext(ctx, "Error decoding control message", "topic", msg.Topic(), "error", err)
+			return
+		}
+		common.MQTTMessagesReceived.WithLabelValues(msg.Topic(), "accepted").Inc()
+		slog.DebugContext(ctx, "Received control message", "target", controlMsg.Target, "action", controlMsg.Action, "room", controlMsg.Room)
+		handleControlMessage(ctx, common.HistorySource(common.HistorySourceMQTT, msg.Topic()), controlMsg)
+	}
+}
+
+func setupMessageHandler(ctx context.Context) mqtt.MessageHandler {
+	return func(client mqtt.Client, msg mqtt.Message) {
+		ctx := common.WithTraceId(ctx)
+
+		var setupMsg common.SetupMessage
+		if err := json.Unmarshal(msg.Payload(), &setupMsg); err != nil {
+			common.MQTTMessagesReceived.WithLabelValues(msg.Topic(), "invalid").Inc()
+			slog.WarnContext(ctx, "Error decoding setup message", "topic", msg.Topic(), "error", err)
+			return
+		}
+		common.MQTTMessagesReceived.WithLabelValues(msg.Topic(), "accepted").Inc()
+		slog.DebugContext(ctx, "Received setup message", "target", setupMsg.Target, "command", setupMsg.Command)
+		handleSetupMessage(ctx, common.HistorySource(common.HistorySourceMQTT, msg.Topic()), setupMsg)
+	}
+}
+
+// onConnect announces cuore as online and (re-)establishes all subscriptions. It runs on every successful
+// connect, so subscriptions survive reconnects even with a clean session.
+func onConnect(ctx context.Context, c mqtt.Client) {
+	slog.Info("Connected to MQTT broker")
+	setMQTTConnected(true)
+	publishRetained(c, statusTopic(), statusOnline)
+	common.SetStatePublisher(func(name string, payload []byte) {
+		publishRetained(c, topic(name), string(payload))
+	})
+
+	if token := c.Subscribe(topic(controlTopic), config.Get().MQTTControlQoS, controlMessageHandler(ctx)); token.Wait() && token.Error() != nil {
+		slog.Error("Error subscribing to MQTT topic", "topic", topic(controlTopic), "error", token.Error())
+	}
+	if token := c.Subscribe(topic(setupTopic), config.Get().MQTTSetupQoS, setupMessageHandler(ctx)); token.Wait() && token.Error() != nil {
+		slog.Error("Error subscribing to MQTT topic", "topic", topic(setupTopic), "error", token.Error())
+	}
+}
+
+func mqttClientOptions(ctx context.Context) (*mqtt.ClientOptions, error) {
+	cfg := config.Get()
+
+	opts := mqtt.NewClientOptions().AddBroker(cfg.MQTTServer)
+	opts.SetClientID(cfg.MQTTClientId)
+	opts.SetCleanSession(cfg.MQTTCleanSession)
+	opts.SetUsername(cfg.MQTTUsername)
+	opts.SetPassword(cfg.MQTTPassword)
+
+	// paho doubles the reconnect delay up to this interval
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(cfg.MQTTMaxReconnectInterval)
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		onConnect(ctx, c)
+	})
+	// the broker announces cuore as offline if the connection drops uncleanly
+	opts.SetWill(statusTopic(), statusOffline, 1, true)
+	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+		slog.Warn("Lost connection to MQTT broker", "error", err)
+		setMQTTConnected(false)
+	})
+	opts.SetReconnectingHandler(func(c mqtt.Client, opts *mqtt.ClientOptions) {
+		slog.Info("Reconnecting to MQTT broker")
+	})
+
+	tlsConfig, err := mqttTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	return opts, nil
+}
+
+// mqttTLSConfig returns nil when neither a CA nor a client certificate is
+// configured, leaving TLS to the broker URL scheme.
+func mqttTLSConfig() (*tls.Config, error) {
+	cfg := config.Get()
+	if cfg.MQTTCACertFile == "" && cfg.MQTTClientCertFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.MQTTCACertFile != "" {
+		caCert, err := os.ReadFile(cfg.MQTTCACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.MQTTCACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.MQTTClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.MQTTClientCertFile, cfg.MQTTClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// connect retries the initial connection with exponential backoff until it
+// succeeds or ctx is cancelled. Later connection losses are handled by
+// paho's auto-reconnect.
+func connect(ctx context.Context, c mqtt.Client) bool {
+	delay := time.Second
+	for {
+		token := c.Connect()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-token.Done():
+		}
+
+		if token.Error() == nil {
+			return true
+		}
+		slog.Warn("Error connecting to MQTT broker", "retry_in", delay, "error", token.Error())
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > config.Get().MQTTMaxReconnectInterval {
+			delay = config.Get().MQTTMaxReconnectInterval
+		}
+	}
+}
+
+func mqttBroker(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	opts, err := mqttClientOptions(ctx)
+	if err != nil {
+		slog.Error("Invalid MQTT configuration", "error", err)
+		return
+	}
+
+	c := mqtt.NewClient(opts)
+	if !connect(ctx, c) {
+		c.Disconnect(0)
+		return
+	}
+
+	availabilityDone := make(chan struct{})
+	go func() {
+		defer close(availabilityDone)
+		publishAvailability(ctx, c)
+	}()
+
+	<-ctx.Done()
+	<-availabilityDone
+
+	slog.Info("Shutting down MQTT broker")
+	if token := c.Unsubscribe(topic(controlTopic), topic(setupTopic)); token.Wait() && token.Error() != nil {
+		slog.Error("Error unsubscribing from MQTT topics", "error", token.Error())
+	}
+
+	for target := range targets {
+		publishRetained(c, integrationStatusTopic(target), statusOffline)
+	}
+	publishRetained(c, statusTopic(), statusOffline)
+
+	c.Disconnect(250)
+	setMQTTConnected(false)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"cuore/common"
+	"cuore/config"
+	"slices"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// fakeClient records publishes and subscriptions. Other methods of the
+// interface are not used by the code under test.
+type fakeClient struct {
+	mqtt.Client
+	published  []string // "topic payload retained"
+	subscribed []string
+}
+
+type doneToken struct{}
+
+func (doneToken) Wait() bool                     { return true }
+func (doneToken) WaitTimeout(time.Duration) bool { return true }
+func (doneToken) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+func (doneToken) Error() error { return nil }
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	entry := topic + " " + payload.(string)
+	if retained {
+		entry += " retained"
+	}
+	c.published = append(c.published, entry)
+	return doneToken{}
+}
+
+func (c *fakeClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.subscribed = append(c.subscribed, topic)
+	return doneToken{}
+}
+
+func TestTopicPrefix(t *testing.T) {
+	defer func(prefix string) { config.Get().MQTTTopicPrefix = prefix }(config.Get().MQTTTopicPrefix)
+
+	for prefix, expected := range map[string]string{"": "control", "home": "home/control", "home/": "home/control"} {
+		config.Get().MQTTTopicPrefix = prefix
+		if got := topic(controlTopic); got != expected {
+			t.Errorf("prefix %q: expected %s, got %s", prefix, expected, got)
+		}
+	}
+}
+
+func TestLastWillAndOnlineStatusUsePrefix(t *testing.T) {
+	defer func(prefix string) { config.Get().MQTTTopicPrefix = prefix }(config.Get().MQTTTopicPrefix)
+	config.Get().MQTTTopicPrefix = "home"
+	defer setMQTTConnected(false)
+	defer common.SetStatePublisher(nil)
+
+	opts, err := mqttClientOptions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.WillEnabled || opts.WillTopic != "home/cuore/status" || string(opts.WillPayload) != statusOffline || !opts.WillRetained || opts.WillQos != 1 {
+		t.Fatalf("expected a retained offline will on the prefixed status topic, got %s %q", opts.WillTopic, opts.WillPayload)
+	}
+
+	client := &fakeClient{}
+	onConnect(context.Background(), client)
+
+	if !slices.Contains(client.published, "home/cuore/status online retained") {
+		t.Fatalf("expected a retained online status, got %v", client.published)
+	}
+	if !slices.Equal(client.subscribed, []string{"home/control", "home/setup"}) {
+		t.Fatalf("expected the prefixed control and setup topics, got %v", client.subscribed)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"cuore/integrations"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// roomStateHandler returns the state of a room per target, for the
+// integrations that keep state per room.
+func roomStateHandler(c *gin.Context) {
+	room := c.Param("room")
+
+	states := gin.H{}
+	for target, integration := range targets {
+		reporter, ok := integration.(integrations.RoomStateReporter)
+		if !ok {
+			continue
+		}
+		if state, ok := reporter.RoomState(room); ok {
+			states[target] = state
+		}
+	}
+
+	if len(states) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound, "message": "Room not found"})
+		return
+	}
+	c.JSON(http.StatusOK, states)
+}
+
+// nowPlayingHandler returns what is playing in a room.
+func nowPlayingHandler(c *gin.Context) {
+	room := c.Param("room")
+
+	for _, integration := range targets {
+		reporter, ok := integration.(integrations.NowPlayingReporter)
+		if !ok {
+			continue
+		}
+		if playing, ok := reporter.NowPlaying(room); ok {
+			c.JSON(http.StatusOK, playing)
+			return
+		}
+	}
+
+	c.JSON(http.StatusNotFound, gin.H{"code": http.StatusNotFound, "message": "Nothing known to be playing in this room"})
+}
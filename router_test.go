@@ -0,0 +1,91 @@
+package main
+
+import (
+	"cuore/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var adminRoutes = []string{
+	"/integrations/sonos/",
+	"/integrations/sonos/refresh",
+	"/integrations/hue/auth",
+	"/api/v1/history",
+	"/api/v1/rooms/Kitchen/state",
+	"/api/v1/rooms/Kitchen/now-playing",
+}
+
+var publicRoutes = []string{
+	"/status",
+	"/healthz",
+	"/readyz",
+	"/album-art/0123456789abcdef0123456789abcdef",
+}
+
+func serve(router *gin.Engine, path string, credentials ...string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", path, nil)
+	if len(credentials) == 2 {
+		req.SetBasicAuth(credentials[0], credentials[1])
+	}
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestAdminRoutesRequireCredentials(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := newRouter()
+
+	defer func(username string, password string) {
+		config.Get().AdminUsername, config.Get().AdminPassword = username, password
+	}(config.Get().AdminUsername, config.Get().AdminPassword)
+	config.Get().AdminUsername = "admin"
+
+	config.Get().AdminPassword = ""
+	for _, path := range adminRoutes {
+		if code := serve(router, path, "admin", "").Code; code != http.StatusServiceUnavailable {
+			t.Errorf("%s: expected 503 without a configured admin password, got %d", path, code)
+		}
+	}
+
+	config.Get().AdminPassword = "secret"
+	for _, path := range adminRoutes {
+		recorder := serve(router, path)
+		if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected a basic auth challenge without credentials, got %d", path, recorder.Code)
+		}
+		if code := serve(router, path, "admin", "wrong").Code; code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401 for a wrong password, got %d", path, code)
+		}
+		if code := serve(router, path, "other", "secret").Code; code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401 for a wrong username, got %d", path, code)
+		}
+	}
+
+	if code := serve(router, "/api/v1/rooms/Kitchen/state", "admin", "secret").Code; code != http.StatusNotFound {
+		t.Errorf("expected valid credentials to reach the handler, got %d", code)
+	}
+}
+
+func TestPublicRoutesSkipAdminAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.Get().CredentialStore = "file"
+	config.Get().EncryptionFilePath = t.TempDir()
+	config.Get().AlbumArtCacheDir = t.TempDir()
+	router := newRouter()
+
+	defer func(password string) { config.Get().AdminPassword = password }(config.Get().AdminPassword)
+	// without a password every admin route is unavailable
+	config.Get().AdminPassword = ""
+
+	for _, path := range publicRoutes {
+		recorder := serve(router, path)
+		if recorder.Code == http.StatusUnauthorized || strings.Contains(recorder.Body.String(), "admin credentials") {
+			t.Errorf("%s: expected no admin auth, got %d %s", path, recorder.Code, recorder.Body.String())
+		}
+	}
+}